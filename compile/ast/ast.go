@@ -0,0 +1,307 @@
+// Package ast declares the syntax tree of a sandlang script
+package ast
+
+import "fmt"
+
+// Pos is a location in a script. Line and Col are 1-based
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Col)
+}
+
+// Position returns the node's location, it is promoted to every node embedding Pos
+func (p Pos) Position() Pos {
+	return p
+}
+
+type Node interface {
+	Position() Pos
+}
+
+// Decl is a top level declaration
+type Decl interface {
+	Node
+	declNode()
+}
+
+// RuleItem is anything that can go where a rule can: a rule, inherit or ext
+type RuleItem interface {
+	Node
+	ruleItemNode()
+}
+
+// File is a whole script, declarations are kept in source order as later ones depend on earlier ones
+type File struct {
+	Name  string
+	Decls []Decl
+}
+
+// ImportDecl is `import "[path]" (as [name])?`. It is replaced by the declarations of the imported file when loading
+type ImportDecl struct {
+	Pos
+	Path string
+	As   string
+}
+
+// GlobalDecl is `global [symbol] <...>`
+type GlobalDecl struct {
+	Pos
+	Symbol string
+	Set    []SetItem
+}
+
+// DefaultDecl is `default [name] [value]`
+type DefaultDecl struct {
+	Pos
+	Name  string
+	Value float64
+}
+
+// PreloadDecl is `preload [color] [color]?`. To equals From if there is only one color
+type PreloadDecl struct {
+	Pos
+	From Color
+	To   Color
+}
+
+// PaletteDecl is `palette [color] [atom]`, the atom a color stands for in level images
+type PaletteDecl struct {
+	Pos
+	Color Color
+	Atom  string
+}
+
+// AtomDecl is `atom [name] (alias [symbol])? {...}`
+type AtomDecl struct {
+	Pos
+	Name     string
+	Alias    string
+	Sections []*Section
+}
+
+// RulesetDecl is `ruleset [name] {...}`
+type RulesetDecl struct {
+	Pos
+	Name  string
+	Rules []RuleItem
+}
+
+// WorldDecl is `world {...}`, cells set before the first tick in the order of its items
+type WorldDecl struct {
+	Pos
+	Items []*WorldItem
+}
+
+// World item kinds
+const (
+	WorldFill  = "fill"
+	WorldPlace = "place"
+	WorldAscii = "ascii"
+)
+
+// WorldItem is `fill rect([x], [y], [w], [h]) [atom]`, `place ([x], [y]) [atom]` or `ascii at ([x], [y]) {...}`
+// The rows of ascii are like pattern rows, with an alias or atom name for each cell, `_` for Empty and `/` to leave a cell as it is
+type WorldItem struct {
+	Pos
+	Kind string
+	X    int
+	Y    int
+	W    int
+	H    int
+	Atom string
+	Rows []*PatternRow
+}
+
+func (*ImportDecl) declNode()  {}
+func (*GlobalDecl) declNode()  {}
+func (*DefaultDecl) declNode() {}
+func (*PreloadDecl) declNode() {}
+func (*PaletteDecl) declNode() {}
+func (*AtomDecl) declNode()    {}
+func (*RulesetDecl) declNode() {}
+func (*WorldDecl) declNode()   {}
+
+// Section is `section [kind] {...}`. Only the fields matching Kind are filled
+type Section struct {
+	Pos
+	Kind   string
+	Props  []*PropDecl
+	Defs   []*SetDecl
+	Rules  []RuleItem
+	Init   []*Step
+	Colors []*ColorLine
+}
+
+// PropDecl is a `def` or `cdef` line of a property section
+type PropDecl struct {
+	Pos
+	Const   bool
+	Name    string
+	Value   float64
+	Color   Color
+	Dynamic bool
+	Key     rune
+}
+
+// SetDecl is `def [symbol] <...>` in a definition section
+type SetDecl struct {
+	Pos
+	Symbol string
+	Set    []SetItem
+}
+
+// SetItem is one member of a set, either an atom name or `^alias`
+type SetItem struct {
+	Pos
+	Name  string
+	Alias bool
+}
+
+// String gives the item as written, which is how sets are stored in the compiled atoms
+func (s SetItem) String() string {
+	if s.Alias {
+		return "^" + s.Name
+	}
+	return s.Name
+}
+
+type Color struct {
+	R uint8
+	G uint8
+	B uint8
+}
+
+// ColorLine is `[condition] => [red], [green], [blue]`, or a log step when Log is set
+type ColorLine struct {
+	Pos
+	Log  *Step
+	Cond *Expr
+	R    *Expr
+	G    *Expr
+	B    *Expr
+}
+
+// RuleDecl is a match block followed by an effect block. Either can be `repeat`ed from the previous rule
+// A `self` rule has no match cells, only conditions, its Header is 0x0
+type RuleDecl struct {
+	Pos
+	Self        bool
+	RepeatMatch bool
+	Header      *MatchHeader
+	Conds       []*Expr
+	Match       *Pattern
+
+	RepeatEffect bool
+	EffectPos    Pos
+	Prob         float64
+	HasProb      bool
+	Steps        []*Step
+}
+
+// MatchHeader is `match ([ox], [oy], [w], [h]) (sym([xy]))?`
+type MatchHeader struct {
+	Pos
+	Ox   int
+	Oy   int
+	W    int
+	H    int
+	XSym bool
+	YSym bool
+}
+
+// InheritDecl is `inherit [name] (-P=[probability])?`
+type InheritDecl struct {
+	Pos
+	Name    string
+	Prob    float64
+	HasProb bool
+}
+
+// ExtDecl is `ext [name] <[param]=[value], ...>`
+type ExtDecl struct {
+	Pos
+	Name   string
+	Params map[string]string
+}
+
+func (*RuleDecl) ruleItemNode()    {}
+func (*InheritDecl) ruleItemNode() {}
+func (*ExtDecl) ruleItemNode()     {}
+
+// Pattern is a grid of cells, one row per line
+type Pattern struct {
+	Pos
+	Rows []*PatternRow
+}
+
+type PatternRow struct {
+	Pos
+	Cells []string
+}
+
+// Step ops
+const (
+	OpSet       = "set"
+	OpInc       = "inc"
+	OpClamp     = "clamp"
+	OpPick      = "def"
+	OpPattern   = "pattern"
+	OpNonBreak  = "non-break"
+	OpAlwaysRun = "always-run"
+	OpShift     = "shift"
+	OpLog       = "log"
+)
+
+// Step is one line of an effect or init block
+//
+//	set/inc: Target, Exprs[0] is the value
+//	clamp: Target, Exprs[0] and Exprs[1] are min and max
+//	def: Symbol, X and Y of pick, relative to the origin if Rel
+//	pattern: Pattern
+//	shift: X and Y
+//	log: Msg, Exprs are the values
+type Step struct {
+	Pos
+	Op      string
+	Msg     string
+	Target  *PropRef
+	Symbol  string
+	X       int
+	Y       int
+	Rel     bool
+	Exprs   []*Expr
+	Pattern *Pattern
+}
+
+// PropRef is a property in square brackets, `[name]`, `[name-x,y]` or `[name@x,y]`
+// X and Y are relative to the origin if Rel
+type PropRef struct {
+	Pos
+	Name     string
+	X        int
+	Y        int
+	HasCoord bool
+	Rel      bool
+}
+
+// String gives the reference as written inside the brackets
+func (r *PropRef) String() string {
+	if r.Rel {
+		return fmt.Sprintf("%v@%v,%v", r.Name, r.X, r.Y)
+	}
+	if r.HasCoord {
+		return fmt.Sprintf("%v-%v,%v", r.Name, r.X, r.Y)
+	}
+	return r.Name
+}
+
+// Expr is a maths statement, kept as source and compiled when lowering
+type Expr struct {
+	Pos
+	Src string
+}
@@ -0,0 +1,475 @@
+package compile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"example.com/compile/ast"
+)
+
+// cells with a fixed meaning in a pattern used to match
+var matchCells = map[string]bool{"*": true, "_": true, "e": true, "x": true, "n": true}
+
+// cells with a fixed meaning in a pattern used to map
+var mapCells = map[string]bool{"x": true, "/": true, "_": true}
+
+// ext functions implemented by the simulator
+var extFuncs = map[string]bool{"randomMove": true, "sandLike": true, "fall": true}
+
+// checker resolves every name used in a script, reporting what the simulator would silently ignore or crash on
+type checker struct {
+	errs     []*CompileError
+	atoms    map[string]*ast.AtomDecl
+	aliases  map[string]bool
+	props    map[string]bool
+	rulesets map[string]*ast.RulesetDecl
+	// rulesets that are inherited at least once
+	inherited map[string]bool
+	// errors already reported, as rules of a ruleset are checked once for every atom inheriting it
+	reported map[string]bool
+	// set symbols used by any rule, for unused global sets
+	used map[string]bool
+}
+
+// check validates a parsed script
+func check(f *ast.File) []*CompileError {
+	c := &checker{
+		atoms:     make(map[string]*ast.AtomDecl),
+		aliases:   make(map[string]bool),
+		props:     make(map[string]bool),
+		rulesets:  make(map[string]*ast.RulesetDecl),
+		inherited: make(map[string]bool),
+		reported:  make(map[string]bool),
+		used:      make(map[string]bool),
+	}
+
+	// names that can be used anywhere in the file, no matter where they are declared
+	aliasOwner := make(map[string]*ast.AtomDecl)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.AtomDecl:
+			if prev, ok := c.atoms[d.Name]; ok {
+				c.errorAt(d.Pos, d.Name, "atom %v is already declared at %v", d.Name, prev.Pos)
+				continue
+			}
+			c.atoms[d.Name] = d
+			if d.Alias != "" {
+				if prev, ok := aliasOwner[d.Alias]; ok {
+					c.warnAt(d.Pos, d.Alias, "alias %v of %v replaces the alias of %v declared at %v", d.Alias, d.Name, prev.Name, prev.Pos)
+				}
+				aliasOwner[d.Alias] = d
+				c.aliases[d.Alias] = true
+			}
+			for _, s := range d.Sections {
+				for _, p := range s.Props {
+					c.props[p.Name] = true
+				}
+			}
+		case *ast.DefaultDecl:
+			c.props[d.Name] = true
+		}
+	}
+	if _, ok := c.atoms["Empty"]; !ok {
+		c.errorAt(ast.Pos{File: f.Name, Line: 1, Col: 1}, "", "there must be an Empty atom")
+	}
+
+	// global sets, atoms and rulesets are only visible after their declaration
+	globals := make(map[string]bool)
+	var globalDecls []*ast.GlobalDecl
+	declared := make(map[string]bool)
+	rulesetScope := make(map[string]map[string]bool)
+	palette := make(map[ast.Color]*ast.PaletteDecl)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.PaletteDecl:
+			if _, ok := c.atoms[d.Atom]; !ok {
+				c.errorAt(d.Pos, d.Atom, "unknown atom %v", d.Atom)
+			}
+			if prev, ok := palette[d.Color]; ok {
+				c.errorAt(d.Pos, d.Atom, "color is already the palette color of %v at %v", prev.Atom, prev.Pos)
+				continue
+			}
+			palette[d.Color] = d
+		case *ast.WorldDecl:
+			c.checkWorld(d)
+		case *ast.GlobalDecl:
+			c.checkSet(d.Set)
+			globals[d.Symbol] = true
+			globalDecls = append(globalDecls, d)
+		case *ast.AtomDecl:
+			if c.atoms[d.Name] != d {
+				continue
+			}
+			c.checkAtom(d, globals, declared)
+			declared[d.Name] = true
+		case *ast.RulesetDecl:
+			if prev, ok := c.rulesets[d.Name]; ok {
+				c.errorAt(d.Pos, d.Name, "ruleset %v is already declared at %v", d.Name, prev.Pos)
+				continue
+			}
+			c.rulesets[d.Name] = d
+			rulesetScope[d.Name] = copySet(globals)
+			declared[d.Name] = true
+		}
+	}
+
+	// rulesets run with the sets of the atoms inheriting them, those that are never inherited only see global sets
+	for name, r := range c.rulesets {
+		if !c.inherited[name] {
+			c.checkRules(r.Rules, rulesetScope[name])
+		}
+	}
+
+	for _, d := range globalDecls {
+		if !c.used[d.Symbol] {
+			c.warnAt(d.Pos, d.Symbol, "global set %v is never used", d.Symbol)
+		}
+	}
+
+	return c.errs
+}
+
+// checkWorld checks the atoms and coordinates of a world section, the size of the world is only known when it runs
+func (c *checker) checkWorld(d *ast.WorldDecl) {
+	for _, it := range d.Items {
+		if it.X < 0 || it.Y < 0 {
+			c.errorAt(it.Pos, it.Kind, "%v at %v, %v is outside of the world", it.Kind, it.X, it.Y)
+		}
+		if it.W <= 0 || it.H <= 0 {
+			c.errorAt(it.Pos, it.Kind, "fill rect needs a positive width and height, got %v, %v", it.W, it.H)
+		}
+		if it.Kind != ast.WorldAscii {
+			if _, ok := c.atoms[it.Atom]; !ok {
+				c.errorAt(it.Pos, it.Atom, "unknown atom %v", it.Atom)
+			}
+			continue
+		}
+		for _, row := range it.Rows {
+			for _, cell := range row.Cells {
+				if _, ok := c.atoms[cell]; !ok && !c.aliases[cell] && cell != "_" && cell != "/" {
+					c.errorAt(row.Pos, cell, "unknown atom or alias %v in ascii, expected an alias, an atom, _ or /", cell)
+				}
+			}
+		}
+	}
+}
+
+func copySet(s map[string]bool) map[string]bool {
+	n := make(map[string]bool, len(s))
+	for k, v := range s {
+		n[k] = v
+	}
+	return n
+}
+
+func (c *checker) errorAt(pos ast.Pos, token string, format string, a ...any) {
+	c.report(pos, token, false, fmt.Sprintf(format, a...))
+}
+
+func (c *checker) warnAt(pos ast.Pos, token string, format string, a ...any) {
+	c.report(pos, token, true, fmt.Sprintf(format, a...))
+}
+
+func (c *checker) report(pos ast.Pos, token string, warning bool, msg string) {
+	key := fmt.Sprintf("%v %v", pos, msg)
+	if c.reported[key] {
+		return
+	}
+	c.reported[key] = true
+	c.errs = append(c.errs, &CompileError{File: pos.File, Line: pos.Line, Col: pos.Col, Token: token, Msg: msg, Warning: warning})
+}
+
+// checkSet checks that every member of a set is an atom or an alias
+func (c *checker) checkSet(set []ast.SetItem) {
+	for _, item := range set {
+		if item.Alias && !c.aliases[item.Name] {
+			c.errorAt(item.Pos, item.String(), "unknown alias %v", item.Name)
+		} else if !item.Alias {
+			if _, ok := c.atoms[item.Name]; !ok {
+				c.errorAt(item.Pos, item.Name, "unknown atom %v", item.Name)
+			}
+		}
+	}
+}
+
+func (c *checker) checkAtom(d *ast.AtomDecl, globals, declared map[string]bool) {
+	// sets visible to the rules of this atom
+	scope := copySet(globals)
+	for _, s := range d.Sections {
+		for _, def := range s.Defs {
+			c.checkSet(def.Set)
+			scope[def.Symbol] = true
+		}
+	}
+
+	used := make(map[string]bool)
+	dynamic, fallback := false, false
+	for _, s := range d.Sections {
+		switch s.Kind {
+		case "property":
+			for _, p := range s.Props {
+				if p.Name == "color" && p.Dynamic {
+					dynamic = true
+				}
+			}
+		case "update":
+			for _, item := range s.Rules {
+				if it, ok := item.(*ast.InheritDecl); ok {
+					c.checkInherit(it, scope, declared)
+				}
+			}
+			c.checkRules(s.Rules, scope)
+			c.usedSymbols(s.Rules, used, make(map[string]bool))
+		case "init":
+			for _, st := range s.Init {
+				for _, e := range st.Exprs {
+					c.checkExpr(e, nil)
+				}
+			}
+		case "color":
+			for _, l := range s.Colors {
+				if l.Log != nil {
+					for _, e := range l.Log.Exprs {
+						c.checkExpr(e, nil)
+					}
+					continue
+				}
+				if strings.TrimSpace(l.Cond.Src) == "true" {
+					fallback = true
+				}
+				for _, e := range []*ast.Expr{l.Cond, l.R, l.G, l.B} {
+					c.checkExpr(e, nil)
+				}
+			}
+		}
+	}
+
+	if dynamic && !fallback {
+		c.warnAt(d.Pos, d.Name, "dynamic color of %v has no `true` line to fall back to", d.Name)
+	}
+	for _, s := range d.Sections {
+		for _, def := range s.Defs {
+			if !used[def.Symbol] {
+				c.warnAt(def.Pos, def.Symbol, "set %v of %v is never used", def.Symbol, d.Name)
+			}
+		}
+	}
+	for sym := range used {
+		c.used[sym] = true
+	}
+}
+
+// usedSymbols adds every set symbol the rules match against to used, following inherits
+func (c *checker) usedSymbols(items []ast.RuleItem, used, visited map[string]bool) {
+	for _, item := range items {
+		switch it := item.(type) {
+		case *ast.ExtDecl:
+			used[strings.TrimPrefix(it.Params["repl"], "~")] = true
+		case *ast.InheritDecl:
+			if visited[it.Name] {
+				continue
+			}
+			visited[it.Name] = true
+			if a, ok := c.atoms[it.Name]; ok {
+				for _, s := range a.Sections {
+					c.usedSymbols(s.Rules, used, visited)
+				}
+			} else if r, ok := c.rulesets[it.Name]; ok {
+				c.usedSymbols(r.Rules, used, visited)
+			}
+		case *ast.RuleDecl:
+			if it.Match == nil {
+				continue
+			}
+			for _, row := range it.Match.Rows {
+				for _, cell := range row.Cells {
+					used[strings.TrimPrefix(cell, "~")] = true
+				}
+			}
+		}
+	}
+}
+
+func (c *checker) checkInherit(it *ast.InheritDecl, scope, declared map[string]bool) {
+	_, isAtom := c.atoms[it.Name]
+	r, isRuleset := c.rulesets[it.Name]
+	switch {
+	case !isAtom && !isRuleset:
+		c.errorAt(it.Pos, it.Name, "inherit of unknown atom or ruleset %v", it.Name)
+	case !declared[it.Name]:
+		c.errorAt(it.Pos, it.Name, "%v must be declared before it is inherited", it.Name)
+	case isRuleset && !isAtom:
+		c.inherited[it.Name] = true
+		c.checkRules(r.Rules, scope)
+	}
+	if it.HasProb && (it.Prob < 0 || it.Prob > 1) {
+		c.errorAt(it.Pos, "-P", "probability %v is not between 0 and 1", it.Prob)
+	}
+}
+
+// checkRules checks rules run with the sets in scope
+func (c *checker) checkRules(items []ast.RuleItem, scope map[string]bool) {
+	var prev *ast.MatchHeader
+	for _, item := range items {
+		switch it := item.(type) {
+		case *ast.ExtDecl:
+			c.checkExt(it, scope)
+		case *ast.InheritDecl:
+			prev = nil
+		case *ast.RuleDecl:
+			h := it.Header
+			if it.RepeatMatch {
+				h = prev
+			}
+			if h == nil {
+				continue
+			}
+			prev = h
+			c.checkRule(it, h, scope)
+		}
+	}
+}
+
+func (c *checker) checkRule(r *ast.RuleDecl, h *ast.MatchHeader, scope map[string]bool) {
+	// a rule without any cells is only there to run its steps
+	conditionless := h.W == 0 && h.H == 0 && r.Match == nil
+	if !conditionless && (h.Ox < 0 || h.Oy < 0 || h.Ox >= h.W || h.Oy >= h.H) {
+		c.errorAt(h.Pos, "match", "origin (%v, %v) is outside of the %vx%v rule", h.Ox, h.Oy, h.W, h.H)
+	}
+
+	for _, e := range r.Conds {
+		c.checkExpr(e, h)
+	}
+	if r.Match != nil {
+		c.checkPattern(r.Match, h, func(cell string) bool {
+			return matchCells[cell] || scope[strings.TrimPrefix(cell, "~")] || c.aliases[cell]
+		})
+	}
+
+	if !r.RepeatEffect && r.HasProb && (r.Prob < 0 || r.Prob > 1) {
+		c.errorAt(r.EffectPos, "P", "probability %v is not between 0 and 1", r.Prob)
+	}
+	// symbols picked by def, in the order the steps run
+	picked := make(map[string]bool)
+	for _, st := range r.Steps {
+		switch st.Op {
+		case ast.OpSet, ast.OpInc, ast.OpClamp:
+			c.checkTarget(st.Target, h)
+			for _, e := range st.Exprs {
+				c.checkExpr(e, h)
+			}
+		case ast.OpLog:
+			for _, e := range st.Exprs {
+				c.checkExpr(e, h)
+			}
+		case ast.OpPick:
+			if r.Self {
+				c.errorAt(st.Pos, st.Symbol, "self rule has no cells to pick")
+				continue
+			}
+			x, y, pick := st.X, st.Y, "pick"
+			if st.Rel {
+				x, y, pick = x+h.Ox, y+h.Oy, "pick@"
+			}
+			if x < 0 || y < 0 || x >= h.W || y >= h.H {
+				c.errorAt(st.Pos, st.Symbol, "%v(%v, %v) is outside of the %vx%v rule", pick, st.X, st.Y, h.W, h.H)
+			}
+			picked[st.Symbol] = true
+		case ast.OpPattern:
+			if r.Self {
+				c.errorAt(st.Pos, "pattern", "self rule has no cells to map a pattern to")
+				continue
+			}
+			c.checkPattern(st.Pattern, h, func(cell string) bool {
+				return mapCells[cell] || picked[cell] || c.aliases[cell]
+			})
+		}
+	}
+}
+
+// checkPattern checks the size of a pattern against the match header and every cell with valid
+func (c *checker) checkPattern(p *ast.Pattern, h *ast.MatchHeader, valid func(string) bool) {
+	if len(p.Rows) != h.H {
+		c.errorAt(p.Pos, "pattern", "pattern has %v rows but the rule is %v high", len(p.Rows), h.H)
+	}
+	for _, row := range p.Rows {
+		if len(row.Cells) != h.W {
+			c.errorAt(row.Pos, strings.Join(row.Cells, " "), "pattern row has %v cells but the rule is %v wide", len(row.Cells), h.W)
+		}
+		for _, cell := range row.Cells {
+			if !valid(cell) {
+				c.errorAt(row.Pos, cell, "unknown alias or symbol %v in pattern", cell)
+			}
+		}
+	}
+}
+
+// checkTarget checks that a step writes to a cell of the rule
+// Coordinates relative to the origin may reach cells around the rule
+func (c *checker) checkTarget(t *ast.PropRef, h *ast.MatchHeader) {
+	if !c.props[t.Name] {
+		c.errorAt(t.Pos, t.Name, "unknown property %v", t.Name)
+	}
+	if t.HasCoord && !t.Rel && (t.X < 0 || t.Y < 0 || t.X >= h.W || t.Y >= h.H) {
+		c.errorAt(t.Pos, t.String(), "[%v] is outside of the %vx%v rule", t, h.W, h.H)
+	}
+	if t.Rel {
+		c.checkReach(t.Pos, t.String(), t.X, t.Y)
+	}
+}
+
+func (c *checker) checkReach(pos ast.Pos, token string, x, y int) {
+	if x < -MaxRuleSize || x > MaxRuleSize || y < -MaxRuleSize || y > MaxRuleSize {
+		c.errorAt(pos, token, "[%v] is more than %v cells away from the origin", token, MaxRuleSize)
+	}
+}
+
+// checkExpr checks that every property read by a maths statement is declared somewhere
+// In a rule h is its header, and the cells read must be in it. Outside of rules h is nil
+func (c *checker) checkExpr(e *ast.Expr, h *ast.MatchHeader) {
+	for _, m := range getEvalBracket.FindAllStringSubmatchIndex(e.Src, -1) {
+		ref := e.Src[m[2]:m[3]]
+		name := PropName(ref)
+		pos := e.Pos
+		pos.Col += m[0]
+		if !c.props[name] {
+			c.errorAt(pos, ref, "unknown property %v", name)
+		}
+		if m[6] >= 0 && h != nil {
+			x, _ := strconv.Atoi(e.Src[m[6]:m[7]])
+			y, _ := strconv.Atoi(e.Src[m[8]:m[9]])
+			if x >= h.W || y >= h.H {
+				c.errorAt(pos, ref, "[%v] is outside of the %vx%v rule", ref, h.W, h.H)
+			}
+		}
+		if m[10] >= 0 {
+			x, _ := strconv.Atoi(e.Src[m[10]:m[11]])
+			y, _ := strconv.Atoi(e.Src[m[12]:m[13]])
+			c.checkReach(pos, ref, x, y)
+		}
+	}
+}
+
+func (c *checker) checkExt(e *ast.ExtDecl, scope map[string]bool) {
+	if !extFuncs[e.Name] {
+		c.errorAt(e.Pos, e.Name, "unknown ext function %v", e.Name)
+		return
+	}
+	repl, ok := e.Params["repl"]
+	if !ok {
+		c.errorAt(e.Pos, e.Name, "ext %v needs a repl parameter", e.Name)
+	} else if !scope[strings.TrimPrefix(repl, "~")] && !c.aliases[repl] {
+		c.errorAt(e.Pos, repl, "unknown alias or symbol %v in repl", repl)
+	}
+	if p, ok := e.Params["prob"]; ok {
+		if v, err := strconv.ParseFloat(p, 64); err != nil || v < 0 || v > 1 {
+			c.errorAt(e.Pos, p, "prob of ext %v must be a number between 0 and 1", e.Name)
+		}
+	}
+	for n := range e.Params {
+		if n != "repl" && n != "prob" {
+			c.errorAt(e.Pos, n, "unknown parameter %v of ext %v", n, e.Name)
+		}
+	}
+}
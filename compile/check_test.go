@@ -0,0 +1,293 @@
+package compile
+
+import (
+	"strings"
+	"testing"
+)
+
+const checkEmpty = `atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+`
+
+// compileErrors compiles src as test.txt and gives its errors and warnings
+func compileErrors(t *testing.T, src string) []*CompileError {
+	t.Helper()
+	_, errs, err := CompileReader(strings.NewReader(src), "test.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return errs
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{
+			name: "unknown alias",
+			src: checkEmpty + `
+atom Sand alias S {
+    section definition {
+        def F <Empty, ^Q>
+    }
+}
+`,
+			line: 9,
+			col:  23,
+			msg:  "unknown alias Q",
+		},
+		{
+			name: "inherit of unknown ruleset",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        inherit Nope
+    }
+}
+`,
+			line: 9,
+			col:  9,
+			msg:  "inherit of unknown atom or ruleset Nope",
+		},
+		{
+			name: "inherit before declaration",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        inherit Fall
+    }
+}
+
+atom Fall {
+    section property {
+        cdef render 0
+    }
+}
+`,
+			line: 9,
+			col:  9,
+			msg:  "Fall must be declared before it is inherited",
+		},
+		{
+			name: "pattern rows do not match the height",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        match (0, 0, 1, 2) {
+            pattern
+            x
+            _
+            _
+        }
+        -> {
+            pattern
+            _
+            x
+        }
+    }
+}
+`,
+			line: 10,
+			col:  13,
+			msg:  "pattern has 3 rows but the rule is 2 high",
+		},
+		{
+			name: "pattern row does not match the width",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        match (0, 0, 1, 2) {
+            pattern
+            x
+            _ _
+        }
+        -> {
+            pattern
+            _
+            x
+        }
+    }
+}
+`,
+			line: 12,
+			col:  13,
+			msg:  "pattern row has 2 cells but the rule is 1 wide",
+		},
+		{
+			name: "origin outside of the rule",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        match (3, 0, 1, 2) {
+            pattern
+            x
+            _
+        }
+        -> {
+            pattern
+            _
+            x
+        }
+    }
+}
+`,
+			line: 9,
+			col:  9,
+			msg:  "origin (3, 0) is outside of the 1x2 rule",
+		},
+		{
+			name: "pick outside of the rule",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        match (0, 0, 1, 2) {
+            pattern
+            x
+            _
+        }
+        -> {
+            def L = pick(0, 2)
+            pattern
+            _
+            L
+        }
+    }
+}
+`,
+			line: 15,
+			col:  13,
+			msg:  "pick(0, 2) is outside of the 1x2 rule",
+		},
+		{
+			name: "property read outside of the rule",
+			src: checkEmpty + `
+atom Sand alias S {
+    section property {
+        cdef render 1
+        def heat 0
+    }
+    section update {
+        match (0, 0, 1, 2) {
+            eval [heat-0,2] > 1
+            pattern
+            x
+            _
+        }
+        -> {
+            pattern
+            _
+            x
+        }
+    }
+}
+`,
+			line: 14,
+			col:  18,
+			msg:  "[heat-0,2] is outside of the 1x2 rule",
+		},
+		{
+			name: "rule larger than the largest rule",
+			src: checkEmpty + `
+atom Sand alias S {
+    section update {
+        match (0, 0, 65, 1) {
+            eval 1 == 1
+        }
+        -> {
+            non-break
+        }
+    }
+}
+`,
+			line: 9,
+			col:  9,
+			msg:  "match header value 65 is out of range, rules can be at most 64x64",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs []*CompileError
+			for _, e := range compileErrors(t, tt.src) {
+				if !e.Warning {
+					errs = append(errs, e)
+				}
+			}
+			if len(errs) != 1 {
+				t.Fatalf("got errors %v, want one", errs)
+			}
+			if e := errs[0]; e.Line != tt.line || e.Col != tt.col || e.Msg != tt.msg {
+				t.Errorf("got %v:%v %q, want %v:%v %q", e.Line, e.Col, e.Msg, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{
+			name: "duplicate alias",
+			src: checkEmpty + `
+atom Sand alias E {
+}
+`,
+			line: 7,
+			col:  1,
+			msg:  "alias E of Sand replaces the alias of Empty declared at test.txt:1:1",
+		},
+		{
+			name: "dynamic color without a true line",
+			src: checkEmpty + `
+atom Sand alias S {
+    section property {
+        cdef render 1
+        cdef color dynamic
+        def heat 0
+    }
+    section color {
+        [heat] > 1 => 255, 0, 0
+    }
+}
+`,
+			line: 7,
+			col:  1,
+			msg:  "dynamic color of Sand has no `true` line to fall back to",
+		},
+		{
+			name: "unused set",
+			src: checkEmpty + `
+atom Sand alias S {
+    section definition {
+        def F <Empty>
+    }
+}
+`,
+			line: 9,
+			col:  9,
+			msg:  "set F of Sand is never used",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := compileErrors(t, tt.src)
+			if n, _ := CountErrors(errs); n > 0 || len(errs) != 1 {
+				t.Fatalf("got %v, want one warning", errs)
+			}
+			if e := errs[0]; e.Line != tt.line || e.Col != tt.col || e.Msg != tt.msg {
+				t.Errorf("got %v:%v %q, want %v:%v %q", e.Line, e.Col, e.Msg, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package compile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestCompileConcurrently compiles scripts with different atoms at the same time, each program must only have its own
+func TestCompileConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atom := fmt.Sprintf("Atom%v", i)
+			src := checkEmpty + fmt.Sprintf(`
+global G%v <Empty>
+
+atom %v alias A {
+    section property {
+        cdef render 1
+    }
+}
+`, i, atom)
+			prog, errs, err := CompileReader(strings.NewReader(src), "test.txt", false)
+			// the global set is only there to be kept apart, it is never used
+			if err != nil || slices.ContainsFunc(errs, func(e *CompileError) bool { return !e.Warning }) {
+				t.Errorf("compile %v: %v %v", atom, err, errs)
+				return
+			}
+			if len(prog.Atoms) != 2 || prog.Atoms[atom] == nil {
+				t.Errorf("program of %v has atoms %v", atom, prog.Atoms)
+			}
+			if len(prog.GlobalSets) != 1 {
+				t.Errorf("program of %v has global sets %v", atom, prog.GlobalSets)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompileTwiceIsIndependent(t *testing.T) {
+	compile := func() *Program {
+		prog, errs, err := CompileReader(strings.NewReader(checkEmpty), "test.txt", false)
+		if err != nil || len(errs) > 0 {
+			t.Fatalf("compile: %v %v", err, errs)
+		}
+		return prog
+	}
+	a, b := compile(), compile()
+	a.Atoms["Empty"].ConstProp["render"] = 5
+	if b.Atoms["Empty"].ConstProp["render"] != 0 {
+		t.Error("changing one program changed the other")
+	}
+}
@@ -0,0 +1,37 @@
+package compile
+
+import "fmt"
+
+// CompileError is a problem found in a script, located by file, line and column (both 1-based)
+// Warnings don't stop a script from running
+type CompileError struct {
+	File    string
+	Line    int
+	Col     int
+	Token   string
+	Msg     string
+	Warning bool
+}
+
+func (e *CompileError) Error() string {
+	msg := e.Msg
+	if e.Warning {
+		msg = "warning: " + msg
+	}
+	if e.Token != "" {
+		return fmt.Sprintf("%v:%v:%v: %v (near %q)", e.File, e.Line, e.Col, msg, e.Token)
+	}
+	return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Col, msg)
+}
+
+// CountErrors gives the number of errors and warnings in errs
+func CountErrors(errs []*CompileError) (errors int, warnings int) {
+	for _, e := range errs {
+		if e.Warning {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	return errors, warnings
+}
@@ -2,4 +2,4 @@ module example.com/compile
 
 go 1.23.3
 
-require github.com/vjeantet/govaluate v1.3.0 // indirect
+require github.com/vjeantet/govaluate v1.3.0
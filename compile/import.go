@@ -0,0 +1,120 @@
+package compile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"example.com/compile/ast"
+)
+
+// loader parses a script and the scripts it imports, merging them into one file
+type loader struct {
+	errs []*CompileError
+	// files in the order they were loaded, each file is only imported once
+	files []string
+	// files being imported, to find cycles
+	stack []string
+	// prefix each file was imported with, empty without one
+	prefixes map[string]string
+	// hash of the source of every file loaded
+	hash hash.Hash
+}
+
+func newLoader(name string) *loader {
+	name = filepath.Clean(name)
+	return &loader{files: []string{name}, stack: []string{name}, prefixes: map[string]string{name: ""}, hash: sha256.New()}
+}
+
+// load parses src, replacing every import with the declarations of the imported file
+func (l *loader) load(src, name string) *ast.File {
+	l.hash.Write([]byte(src))
+	file, errs := parse(src, name)
+	l.errs = append(l.errs, errs...)
+
+	merged := &ast.File{Name: name}
+	for _, d := range file.Decls {
+		if imp, ok := d.(*ast.ImportDecl); ok {
+			merged.Decls = append(merged.Decls, l.importFile(imp, name)...)
+		} else {
+			merged.Decls = append(merged.Decls, d)
+		}
+	}
+	return merged
+}
+
+func (l *loader) importFile(imp *ast.ImportDecl, from string) []ast.Decl {
+	path := imp.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	path = filepath.Clean(path)
+
+	if i := slices.Index(l.stack, path); i >= 0 {
+		cycle := append(slices.Clone(l.stack[i:]), path)
+		l.errorAt(imp.Pos, imp.Path, "import cycle: %v", strings.Join(cycle, " -> "))
+		return nil
+	}
+	if prefix, ok := l.prefixes[path]; ok {
+		// importing a file again with the same prefix would only declare everything twice
+		if prefix != imp.As {
+			l.errorAt(imp.Pos, imp.Path, "%v is already imported %v, it cannot be imported %v too", imp.Path, importedAs(prefix), importedAs(imp.As))
+		}
+		return nil
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		l.errorAt(imp.Pos, imp.Path, "cannot import: %v", err)
+		return nil
+	}
+
+	l.files = append(l.files, path)
+	l.prefixes[path] = imp.As
+	l.stack = append(l.stack, path)
+	f := l.load(string(src), path)
+	l.stack = l.stack[:len(l.stack)-1]
+
+	if imp.As != "" {
+		namespace(f.Decls, imp.As)
+	}
+	return f.Decls
+}
+
+func importedAs(prefix string) string {
+	if prefix == "" {
+		return "without a prefix"
+	}
+	return fmt.Sprintf("as %v", prefix)
+}
+
+func (l *loader) errorAt(pos ast.Pos, token string, format string, a ...any) {
+	l.errs = append(l.errs, &CompileError{File: pos.File, Line: pos.Line, Col: pos.Col, Token: token, Msg: fmt.Sprintf(format, a...)})
+}
+
+// namespace renames the rulesets of an imported file to `ns.name`, along with the inherits using them
+func namespace(decls []ast.Decl, ns string) {
+	rulesets := make(map[string]bool)
+	for _, d := range decls {
+		if r, ok := d.(*ast.RulesetDecl); ok {
+			rulesets[r.Name] = true
+			r.Name = ns + "." + r.Name
+		}
+	}
+	for _, d := range decls {
+		a, ok := d.(*ast.AtomDecl)
+		if !ok {
+			continue
+		}
+		for _, s := range a.Sections {
+			for _, item := range s.Rules {
+				if it, ok := item.(*ast.InheritDecl); ok && rulesets[it.Name] {
+					it.Name = ns + "." + it.Name
+				}
+			}
+		}
+	}
+}
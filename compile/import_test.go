@@ -0,0 +1,51 @@
+package compile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportTwiceWithOtherPrefix(t *testing.T) {
+	dir := t.TempDir()
+	lib := `
+ruleset Fall {
+    match (0, 0, 1, 2) {
+        pattern
+        x
+        _
+    }
+    -> {
+        pattern
+        _
+        x
+    }
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "lib.txt"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := `import "lib.txt"
+import "lib.txt"
+import "lib.txt" as temp
+
+atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+`
+	_, errs, err := CompileReader(strings.NewReader(src), filepath.Join(dir, "main.txt"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want one", errs)
+	}
+	e := errs[0]
+	want := "lib.txt is already imported without a prefix, it cannot be imported as temp too"
+	if e.Line != 3 || e.Col != 1 || e.Msg != want {
+		t.Errorf("got %v:%v %q, want 3:1 %q", e.Line, e.Col, e.Msg, want)
+	}
+}
@@ -0,0 +1,265 @@
+package compile
+
+import (
+	"strings"
+	"unicode"
+
+	"example.com/compile/ast"
+)
+
+type tokenKind uint8
+
+const (
+	tEOF tokenKind = iota
+	tIllegal
+	tIdent
+	tNumber
+	tColor
+	tString
+	tLBrace
+	tRBrace
+	tLParen
+	tRParen
+	tLAngle
+	tRAngle
+	tLBracket
+	tRBracket
+	tComma
+	tEqual
+	tArrow
+	tFatArrow
+	tMinus
+	tCaret
+	tTilde
+	tAt
+)
+
+var tokenNames = map[tokenKind]string{
+	tEOF:      "end of file",
+	tIllegal:  "illegal character",
+	tIdent:    "name",
+	tNumber:   "number",
+	tColor:    "color",
+	tString:   "string",
+	tLBrace:   "{",
+	tRBrace:   "}",
+	tLParen:   "(",
+	tRParen:   ")",
+	tLAngle:   "<",
+	tRAngle:   ">",
+	tLBracket: "[",
+	tRBracket: "]",
+	tComma:    ",",
+	tEqual:    "=",
+	tArrow:    "->",
+	tFatArrow: "=>",
+	tMinus:    "-",
+	tCaret:    "^",
+	tTilde:    "~",
+	tAt:       "@",
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  ast.Pos
+	// offset of the first byte of the token in the source
+	off int
+}
+
+// lexer splits a script into tokens. Maths statements and pattern rows are not tokenized,
+// the parser reads them as raw text with rawExpr and rawRow instead
+type lexer struct {
+	src  string
+	file string
+	off  int
+	line int
+	col  int
+	// unterminated comments are reported through this
+	errf func(pos ast.Pos, token string, msg string)
+}
+
+func newLexer(src, file string, errf func(ast.Pos, string, string)) *lexer {
+	return &lexer{src: src, file: file, line: 1, col: 1, errf: errf}
+}
+
+func (l *lexer) pos() ast.Pos {
+	return ast.Pos{File: l.file, Line: l.line, Col: l.col}
+}
+
+func (l *lexer) peekByte(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance() {
+	if l.off >= len(l.src) {
+		return
+	}
+	if l.src[l.off] == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.off++
+}
+
+// seek moves back to a token that was already read
+func (l *lexer) seek(t token) {
+	l.off = t.off
+	l.line = t.pos.Line
+	l.col = t.pos.Col
+}
+
+// skipSpace skips whitespace and comments, including newlines
+func (l *lexer) skipSpace() {
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.advance()
+		case c == '/' && l.peekByte(1) == '/':
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance()
+			}
+		case c == '/' && l.peekByte(1) == '*':
+			start := l.pos()
+			l.advance()
+			l.advance()
+			for l.off < len(l.src) && !(l.src[l.off] == '*' && l.peekByte(1) == '/') {
+				l.advance()
+			}
+			if l.off >= len(l.src) {
+				l.errf(start, "/*", "unterminated comment")
+				return
+			}
+			l.advance()
+			l.advance()
+		default:
+			return
+		}
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() token {
+	l.skipSpace()
+	t := token{pos: l.pos(), off: l.off}
+	if l.off >= len(l.src) {
+		t.kind = tEOF
+		return t
+	}
+
+	c := l.src[l.off]
+	switch {
+	case isIdentStart(c):
+		// names may contain a hyphen or a dot followed by a letter, eg non-break or temp.TemperatureMod
+		for l.off < len(l.src) && (isIdentChar(l.src[l.off]) || (l.src[l.off] == '-' || l.src[l.off] == '.') && isIdentStart(l.peekByte(1))) {
+			l.advance()
+		}
+		t.kind = tIdent
+	case isDigit(c) || c == '.' && isDigit(l.peekByte(1)):
+		for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '.') {
+			l.advance()
+		}
+		// aliases and keys such as 1a are read as names
+		if l.off < len(l.src) && isIdentStart(l.src[l.off]) {
+			for l.off < len(l.src) && isIdentChar(l.src[l.off]) {
+				l.advance()
+			}
+			t.kind = tIdent
+		} else {
+			t.kind = tNumber
+		}
+	case c == '#':
+		l.advance()
+		for l.off < len(l.src) && isIdentChar(l.src[l.off]) {
+			l.advance()
+		}
+		t.kind = tColor
+	case c == '"':
+		l.advance()
+		for l.off < len(l.src) && l.src[l.off] != '"' && l.src[l.off] != '\n' {
+			l.advance()
+		}
+		if l.off < len(l.src) && l.src[l.off] == '"' {
+			l.advance()
+			t.kind = tString
+		} else {
+			t.kind = tIllegal
+		}
+	case c == '-' && l.peekByte(1) == '>':
+		l.advance()
+		l.advance()
+		t.kind = tArrow
+	case c == '=' && l.peekByte(1) == '>':
+		l.advance()
+		l.advance()
+		t.kind = tFatArrow
+	default:
+		kinds := map[byte]tokenKind{
+			'{': tLBrace, '}': tRBrace, '(': tLParen, ')': tRParen, '<': tLAngle, '>': tRAngle,
+			'[': tLBracket, ']': tRBracket, ',': tComma, '=': tEqual, '-': tMinus, '^': tCaret, '~': tTilde, '@': tAt,
+		}
+		if k, ok := kinds[c]; ok {
+			t.kind = k
+		} else {
+			t.kind = tIllegal
+		}
+		l.advance()
+	}
+	t.text = l.src[t.off:l.off]
+	return t
+}
+
+// rawExpr reads a maths statement up to the end of the line, a `}` closing the enclosing block,
+// a `//` comment, and if asked a top level `,` or `=>`
+func (l *lexer) rawExpr(stopComma, stopArrow bool) (string, ast.Pos) {
+	for l.off < len(l.src) && (l.src[l.off] == ' ' || l.src[l.off] == '\t') {
+		l.advance()
+	}
+	start, startPos := l.off, l.pos()
+	depth := 0
+scan:
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == '\n':
+			break scan
+		case c == '/' && l.peekByte(1) == '/':
+			break scan
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth <= 0 && c == '}':
+			break scan
+		case depth <= 0 && stopComma && c == ',':
+			break scan
+		case depth <= 0 && stopArrow && c == '=' && l.peekByte(1) == '>':
+			break scan
+		}
+		l.advance()
+	}
+	return strings.TrimRightFunc(l.src[start:l.off], unicode.IsSpace), startPos
+}
+
+// rawRow reads one row of a pattern up to the end of the line, a `}` or a `//` comment
+func (l *lexer) rawRow() ([]string, ast.Pos) {
+	s, pos := l.rawExpr(false, false)
+	return strings.Fields(s), pos
+}
@@ -0,0 +1,458 @@
+package compile
+
+import (
+	"fmt"
+	"math"
+
+	"example.com/compile/ast"
+	"github.com/vjeantet/govaluate"
+)
+
+// lowerer turns the syntax tree into the atoms and rules used by the simulator
+type lowerer struct {
+	prog       *Program
+	log        bool
+	errs       []*CompileError
+	currAtomId int
+	newRuleId  uint16
+}
+
+func lower(f *ast.File, log bool) (*Program, []*CompileError) {
+	c := &lowerer{prog: newProgram(), log: log}
+	aliases := make(map[string]string)
+	var worlds []*ast.WorldDecl
+
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.GlobalDecl:
+			c.prog.GlobalSets[d.Symbol] = setStrings(d.Set)
+			if log {
+				fmt.Printf("%v Set global set %v to %v\n", d.Line, d.Symbol, c.prog.GlobalSets[d.Symbol])
+			}
+		case *ast.DefaultDecl:
+			c.prog.Defaults[d.Name] = float32(d.Value)
+		case *ast.PreloadDecl:
+			from, to := Color(d.From), Color(d.To)
+			if to.R < from.R {
+				from.R, to.R = to.R, from.R
+			}
+			if to.G < from.G {
+				from.G, to.G = to.G, from.G
+			}
+			if to.B < from.B {
+				from.B, to.B = to.B, from.B
+			}
+			c.prog.Preload = append(c.prog.Preload, [2]Color{from, to})
+		case *ast.PaletteDecl:
+			c.prog.Palette[Color(d.Color)] = d.Atom
+		case *ast.WorldDecl:
+			worlds = append(worlds, d)
+		case *ast.AtomDecl:
+			if d.Alias != "" {
+				aliases[d.Alias] = d.Name
+			}
+			c.lowerAtom(d)
+		case *ast.RulesetDecl:
+			if log {
+				fmt.Println(d.Line, "Start of ruleset:", d.Name)
+			}
+			c.prog.Rulesets[d.Name] = c.lowerRules(d.Rules, nil)
+		}
+	}
+
+	for _, d := range worlds {
+		c.lowerWorld(d, aliases)
+	}
+
+	return c.prog, c.errs
+}
+
+// lowerWorld adds the items of a world section to the layout, an ascii item becomes one placement for each cell
+func (c *lowerer) lowerWorld(d *ast.WorldDecl, aliases map[string]string) {
+	for _, it := range d.Items {
+		if it.Kind != ast.WorldAscii {
+			c.prog.World = append(c.prog.World, Placement{X: it.X, Y: it.Y, W: it.W, H: it.H, Atom: it.Atom})
+			continue
+		}
+		for dy, row := range it.Rows {
+			for dx, cell := range row.Cells {
+				atom := cell
+				switch {
+				case cell == "/":
+					continue
+				case cell == "_":
+					atom = "Empty"
+				case aliases[cell] != "":
+					atom = aliases[cell]
+				}
+				c.prog.World = append(c.prog.World, Placement{X: it.X + dx, Y: it.Y + dy, W: 1, H: 1, Atom: atom})
+			}
+		}
+	}
+}
+
+func (c *lowerer) errorAt(pos ast.Pos, token string, format string, a ...any) {
+	c.errs = append(c.errs, &CompileError{File: pos.File, Line: pos.Line, Col: pos.Col, Token: token, Msg: fmt.Sprintf(format, a...)})
+}
+
+func setStrings(items []ast.SetItem) []string {
+	set := make([]string, len(items))
+	for i, item := range items {
+		set[i] = item.String()
+	}
+	return set
+}
+
+// math compiles a maths statement, ox and oy are the rule origin that coordinates are relative to
+func (c *lowerer) math(e *ast.Expr, ox, oy int, initMode bool) (map[string][][2]int, map[string][3]float64, *govaluate.EvaluableExpression, bool) {
+	vars, randVars, eval, err := compileMath(e.Src, ox, oy, initMode)
+	if err != nil {
+		c.errorAt(e.Pos, e.Src, "invalid maths statement: %v", err)
+		return nil, nil, nil, false
+	}
+	return vars, randVars, eval, true
+}
+
+func (c *lowerer) lowerAtom(d *ast.AtomDecl) {
+	if c.currAtomId > math.MaxUint16 {
+		c.errorAt(d.Pos, d.Name, "too many atoms, there can be at most %v", math.MaxUint16+1)
+		return
+	}
+	atom := &AtomRef{Id: uint16(c.currAtomId), Prop: make(map[string]float32), ConstProp: make(map[string]float32), Def: make(map[string][]string), Key: ' ', DynamicColor: false, Alias: d.Alias}
+	c.prog.Atoms[d.Name] = atom
+	for sym, a := range c.prog.GlobalSets {
+		atom.Def[sym] = a
+	}
+	if c.log {
+		fmt.Println(d.Line, "Start of atom dec:", d.Name, c.currAtomId)
+	}
+	c.currAtomId++
+
+	for _, s := range d.Sections {
+		switch s.Kind {
+		case "property":
+			for _, p := range s.Props {
+				switch {
+				case p.Name == "color" && p.Dynamic:
+					atom.DynamicColor = true
+				case p.Name == "color":
+					atom.Color = Color(p.Color)
+					atom.HasColor = true
+				case p.Name == "key":
+					atom.Key = p.Key
+				case p.Const:
+					atom.ConstProp[p.Name] = float32(p.Value)
+				default:
+					atom.Prop[p.Name] = float32(p.Value)
+				}
+			}
+		case "definition":
+			for _, def := range s.Defs {
+				atom.Def[def.Symbol] = setStrings(def.Set)
+				if c.log {
+					fmt.Printf("%v Set definition %v of %v to %v\n", def.Line, def.Symbol, d.Name, atom.Def[def.Symbol])
+				}
+			}
+		case "update":
+			c.lowerRules(s.Rules, atom)
+		case "init":
+			for _, st := range s.Init {
+				if st.Op == ast.OpLog {
+					if step, ok := c.logStep(st, 0, 0, true); ok {
+						atom.Init = append(atom.Init, step)
+					}
+					continue
+				}
+				if st.Target.HasCoord {
+					c.errorAt(st.Target.Pos, st.Target.String(), "init steps can only target the atom itself")
+					continue
+				}
+				vars, randVars, eval, ok := c.math(st.Exprs[0], 0, 0, true)
+				if !ok {
+					continue
+				}
+				atom.Init = append(atom.Init, Step{Opcode: 5, Name: []string{st.Target.Name}, Operand: []float64{0, 0}, Eval: eval, Vars: vars, RandVars: randVars})
+			}
+		case "color":
+			for _, l := range s.Colors {
+				if l.Log != nil {
+					if step, ok := c.logStep(l.Log, 0, 0, true); ok {
+						atom.ColorRules = append(atom.ColorRules, ColorRule{Log: &step})
+					}
+					continue
+				}
+				vars, randVars, eval, ok := c.math(l.Cond, 0, 0, true)
+				rvars, rrandVars, reval, rok := c.math(l.R, 0, 0, true)
+				gvars, grandVars, geval, gok := c.math(l.G, 0, 0, true)
+				bvars, brandVars, beval, bok := c.math(l.B, 0, 0, true)
+				if !ok || !rok || !gok || !bok {
+					continue
+				}
+
+				newColorRule := ColorRule{}
+				newColorRule.Cond = Condition{Names: vars, RandVars: randVars, Expr: eval}
+				newColorRule.Col = DynamicColor{R: ColorComponent{Vars: rvars, RandVars: rrandVars, Eval: reval}, G: ColorComponent{Vars: gvars, RandVars: grandVars, Eval: geval}, B: ColorComponent{Vars: bvars, RandVars: brandVars, Eval: beval}}
+				atom.ColorRules = append(atom.ColorRules, newColorRule)
+			}
+		}
+	}
+}
+
+// lowerRules lowers the rules of an update section into atom, or of a ruleset when atom is nil
+// The rules of a ruleset are returned
+func (c *lowerer) lowerRules(items []ast.RuleItem, atom *AtomRef) []Rule {
+	var rules []Rule
+	// the previous rule and whether it was always-run, for repeat
+	var prev *Rule
+	prevAlways := false
+
+	for _, item := range items {
+		switch it := item.(type) {
+		case *ast.InheritDecl:
+			if atom == nil {
+				c.errorAt(it.Pos, it.Name, "inherit is not allowed in a ruleset")
+				continue
+			}
+			var target []Rule
+			if v, ok := c.prog.Atoms[it.Name]; ok {
+				target = v.Rules
+			} else if v, ok := c.prog.Rulesets[it.Name]; ok {
+				target = v
+			}
+
+			for _, r := range target {
+				r.Id = c.newRuleId
+				if it.HasProb {
+					r.Prob = it.Prob
+				}
+				atom.Rules = append(atom.Rules, r)
+				c.newRuleId++
+				prev, prevAlways = &r, false
+			}
+		case *ast.ExtDecl:
+			if atom == nil {
+				c.errorAt(it.Pos, it.Name, "ext is not allowed in a ruleset")
+				continue
+			}
+			atom.ExtRules = append(atom.ExtRules, ExtRule{Name: it.Name, Param: it.Params})
+		case *ast.RuleDecl:
+			rule, always, ok := c.lowerRule(it, prev, prevAlways)
+			if !ok {
+				continue
+			}
+			if always && atom == nil {
+				c.errorAt(it.EffectPos, "always-run", "always-run is not allowed in a ruleset")
+				continue
+			}
+			switch {
+			case atom == nil:
+				rules = append(rules, rule)
+			case always:
+				atom.AlwaysRules = append(atom.AlwaysRules, rule)
+			default:
+				atom.Rules = append(atom.Rules, rule)
+			}
+			c.newRuleId++
+			prev, prevAlways = &rule, always
+		}
+	}
+
+	return rules
+}
+
+// lowerRule lowers one rule, prev is the rule before it for repeat match and repeat effect
+func (c *lowerer) lowerRule(d *ast.RuleDecl, prev *Rule, prevAlways bool) (Rule, bool, bool) {
+	rule := Rule{Id: c.newRuleId, Prob: 1}
+	always := false
+
+	if d.RepeatMatch {
+		if prev == nil {
+			c.errorAt(d.Pos, "repeat", "repeat match without a previous rule")
+			return rule, false, false
+		}
+		rule.MatchCon = prev.MatchCon
+		rule.Match = prev.Match
+		rule.NoMatchPattern = prev.NoMatchPattern
+		rule.Self = prev.Self
+		rule.W = prev.W
+		rule.H = prev.H
+		rule.Ox = prev.Ox
+		rule.Oy = prev.Oy
+		rule.XSym = prev.XSym
+		rule.YSym = prev.YSym
+	} else {
+		h := d.Header
+		for _, v := range []int{h.Ox, h.Oy, h.W, h.H} {
+			if v < 0 || v > MaxRuleSize {
+				c.errorAt(h.Pos, "match", "match header value %v is out of range, rules can be at most %vx%v", v, MaxRuleSize, MaxRuleSize)
+				return rule, false, false
+			}
+		}
+		rule.W = uint16(h.W)
+		rule.H = uint16(h.H)
+		rule.Ox = int16(h.Ox)
+		rule.Oy = int16(h.Oy)
+		rule.XSym = h.XSym
+		rule.YSym = h.YSym
+
+		for _, cond := range d.Conds {
+			vars, randVars, eval, ok := c.math(cond, int(rule.Ox), int(rule.Oy), false)
+			if !ok {
+				continue
+			}
+			rule.MatchCon = append(rule.MatchCon, Condition{Names: vars, Expr: eval, RandVars: randVars})
+		}
+		if d.Match != nil {
+			for _, row := range d.Match.Rows {
+				rule.Match = append(rule.Match, row.Cells...)
+			}
+		}
+		switch {
+		case d.Self:
+			rule.Self = true
+		case len(rule.Match) <= 0:
+			rule.NoMatchPattern = true
+		}
+	}
+	if c.log {
+		fmt.Printf("%v Rule %v (%v, %v, %v, %v)\n", d.Line, rule.Id, rule.Ox, rule.Oy, rule.W, rule.H)
+	}
+
+	if d.RepeatEffect {
+		if prev == nil {
+			c.errorAt(d.EffectPos, "repeat", "repeat effect without a previous rule")
+			return rule, false, false
+		}
+		rule.DontBreak = prev.DontBreak
+		rule.Pat = prev.Pat
+		rule.Steps = prev.Steps
+		rule.Prob = prev.Prob
+		rule.Shift = prev.Shift
+		return rule, prevAlways, true
+	}
+
+	rule.Prob = d.Prob
+	ox, oy := int(rule.Ox), int(rule.Oy)
+	for _, st := range d.Steps {
+		var operand []float64
+		if st.Target != nil {
+			if st.Target.Rel {
+				operand = []float64{float64(st.Target.X), float64(st.Target.Y)}
+			} else if st.Target.HasCoord {
+				operand = []float64{float64(st.Target.X - ox), float64(st.Target.Y - oy)}
+			} else {
+				operand = []float64{0, 0}
+			}
+		}
+
+		switch st.Op {
+		case ast.OpSet, ast.OpInc:
+			vars, randVars, eval, ok := c.math(st.Exprs[0], ox, oy, false)
+			if !ok {
+				continue
+			}
+			opcode := uint8(1)
+			if st.Op == ast.OpInc {
+				opcode = 2
+			}
+			rule.Steps = append(rule.Steps, Step{Opcode: opcode, Name: []string{st.Target.String()}, Eval: eval, Vars: vars, Operand: operand, RandVars: randVars})
+		case ast.OpClamp:
+			minVars, minRandVars, minEval, minOk := c.math(st.Exprs[0], ox, oy, false)
+			maxVars, maxRandVars, maxEval, maxOk := c.math(st.Exprs[1], ox, oy, false)
+			if !minOk || !maxOk {
+				continue
+			}
+			rule.Steps = append(rule.Steps, Step{Opcode: 3, Name: []string{st.Target.String()}, Eval: minEval, Vars: minVars, Operand: operand, RandVars: minRandVars})
+			rule.Steps = append(rule.Steps, Step{Opcode: 6, Name: []string{st.Target.String()}, Eval: maxEval, Vars: maxVars, Operand: operand, RandVars: maxRandVars})
+		case ast.OpPick:
+			x, y := st.X, st.Y
+			if st.Rel {
+				x, y = x+ox, y+oy
+			}
+			rule.Steps = append(rule.Steps, Step{Opcode: 5, Name: []string{st.Symbol}, Operand: []float64{float64(x), float64(y)}})
+			if c.log {
+				fmt.Printf("%v Added step to define %v at coord (%v, %v)\n", st.Line, st.Symbol, x, y)
+			}
+		case ast.OpPattern:
+			if rule.Pat != nil {
+				c.errorAt(st.Pos, "pattern", "effect already has a pattern")
+				continue
+			}
+			for _, row := range st.Pattern.Rows {
+				rule.Pat = append(rule.Pat, row.Cells...)
+			}
+			rule.Steps = append(rule.Steps, Step{Opcode: 4})
+		case ast.OpNonBreak:
+			rule.DontBreak = true
+		case ast.OpAlwaysRun:
+			always = true
+		case ast.OpShift:
+			rule.Shift = [2]int{st.X, st.Y}
+		case ast.OpLog:
+			if step, ok := c.logStep(st, ox, oy, false); ok {
+				rule.Steps = append(rule.Steps, step)
+			}
+		}
+	}
+
+	c.reach(&rule)
+	return rule, always, true
+}
+
+// logStep compiles a log step, its values are bound the same way as set
+func (c *lowerer) logStep(st *ast.Step, ox, oy int, initMode bool) (Step, bool) {
+	step := Step{Opcode: 7, Msg: st.Msg}
+	for _, e := range st.Exprs {
+		vars, randVars, eval, ok := c.math(e, ox, oy, initMode)
+		if !ok {
+			return step, false
+		}
+		step.Args = append(step.Args, LogArg{Src: e.Src, Eval: eval, Vars: vars, RandVars: randVars})
+	}
+	return step, true
+}
+
+// reach widens Program.Reach to cover every cell rule reads or writes
+func (c *lowerer) reach(rule *Rule) {
+	ox, oy := int(rule.Ox), int(rule.Oy)
+	if len(rule.Match) > 0 || len(rule.Pat) > 0 {
+		c.prog.Reach = max(c.prog.Reach, ox, oy, int(rule.W)-ox-1, int(rule.H)-oy-1)
+	}
+	offset := func(v [2]int) {
+		c.prog.Reach = max(c.prog.Reach, abs(v[0]), abs(v[1]))
+	}
+	for _, con := range rule.MatchCon {
+		for _, l := range con.Names {
+			for _, v := range l {
+				offset(v)
+			}
+		}
+	}
+	for _, st := range rule.Steps {
+		for _, l := range st.Vars {
+			for _, v := range l {
+				offset(v)
+			}
+		}
+		for _, arg := range st.Args {
+			for _, l := range arg.Vars {
+				for _, v := range l {
+					offset(v)
+				}
+			}
+		}
+		switch st.Opcode {
+		case 1, 2, 3, 6:
+			offset([2]int{int(st.Operand[0]), int(st.Operand[1])})
+		case 5:
+			offset([2]int{int(st.Operand[0]) - ox, int(st.Operand[1]) - oy})
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,992 @@
+package compile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"example.com/compile/ast"
+)
+
+// keywords that start a step or a statement of a match block, a pattern ends at the first line starting with one
+var stepKeywords = map[string]bool{
+	"eval":       true,
+	"pattern":    true,
+	"set":        true,
+	"inc":        true,
+	"clamp":      true,
+	"def":        true,
+	"non-break":  true,
+	"always-run": true,
+	"shift":      true,
+	"log":        true,
+}
+
+type parser struct {
+	lex  *lexer
+	tok  token
+	errs []*CompileError
+}
+
+// parse builds the syntax tree of a script. Errors are collected and parsing carries on after each of them
+func parse(src, name string) (*ast.File, []*CompileError) {
+	p := &parser{}
+	p.lex = newLexer(src, name, func(pos ast.Pos, token, msg string) {
+		p.errorAt(pos, token, "%v", msg)
+	})
+	p.next()
+
+	f := &ast.File{Name: name}
+	for p.tok.kind != tEOF {
+		start := p.tok.off
+		if d := p.parseDecl(); d != nil {
+			f.Decls = append(f.Decls, d)
+		}
+		p.progress(start)
+	}
+	return f, p.errs
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *parser) errorAt(pos ast.Pos, token string, format string, a ...any) {
+	p.errs = append(p.errs, &CompileError{File: pos.File, Line: pos.Line, Col: pos.Col, Token: token, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (p *parser) errorf(format string, a ...any) {
+	p.errorAt(p.tok.pos, p.tok.text, format, a...)
+}
+
+// skipLine drops the rest of a broken statement, including any block it opened on line
+// It stops before a `}` closing the enclosing block so that block still closes
+func (p *parser) skipLine(line int) {
+	depth := 0
+	for !p.is(tEOF) && (depth > 0 || p.tok.pos.Line == line) {
+		switch p.tok.kind {
+		case tLBrace:
+			depth++
+		case tRBrace:
+			if depth == 0 {
+				return
+			}
+			depth--
+		}
+		p.next()
+	}
+}
+
+// progress makes sure a statement that failed without reading anything does not loop forever
+func (p *parser) progress(start int) {
+	if p.tok.off == start && p.tok.kind != tEOF {
+		p.next()
+	}
+}
+
+func (p *parser) is(kind tokenKind) bool {
+	return p.tok.kind == kind
+}
+
+func (p *parser) isWord(word string) bool {
+	return p.tok.kind == tIdent && p.tok.text == word
+}
+
+func (p *parser) expect(kind tokenKind, what string) bool {
+	if p.tok.kind != kind {
+		p.errorf("%v expects %v", what, tokenNames[kind])
+		return false
+	}
+	p.next()
+	return true
+}
+
+func (p *parser) expectWord(word string, what string) bool {
+	if !p.isWord(word) {
+		p.errorf("%v expects %v", what, word)
+		return false
+	}
+	p.next()
+	return true
+}
+
+func (p *parser) ident(what string) (string, bool) {
+	if p.tok.kind != tIdent && p.tok.kind != tNumber {
+		p.errorf("%v expects a name", what)
+		return "", false
+	}
+	s := p.tok.text
+	p.next()
+	return s, true
+}
+
+func (p *parser) number(what string) (float64, bool) {
+	neg := false
+	if p.is(tMinus) {
+		neg = true
+		p.next()
+	}
+	if p.tok.kind != tNumber {
+		p.errorf("%v expects a number", what)
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(p.tok.text, 64)
+	if err != nil {
+		p.errorf("%v expects a number", what)
+		return 0, false
+	}
+	p.next()
+	if neg {
+		v = -v
+	}
+	return v, true
+}
+
+func (p *parser) integer(what string) (int, bool) {
+	neg := false
+	if p.is(tMinus) {
+		neg = true
+		p.next()
+	}
+	if p.tok.kind != tNumber {
+		p.errorf("%v expects an integer", what)
+		return 0, false
+	}
+	v, err := strconv.Atoi(p.tok.text)
+	if err != nil {
+		p.errorf("%v expects an integer", what)
+		return 0, false
+	}
+	p.next()
+	if neg {
+		v = -v
+	}
+	return v, true
+}
+
+func (p *parser) color(what string) (ast.Color, bool) {
+	if p.tok.kind != tColor {
+		p.errorf("%v expects a color in the form #RRGGBB", what)
+		return ast.Color{}, false
+	}
+	col, err := parseColor(p.tok.text)
+	if err != nil {
+		p.errorf("%v", err)
+		return ast.Color{}, false
+	}
+	p.next()
+	return ast.Color(col), true
+}
+
+// block parses `{ ... }`, calling item until the closing brace
+func (p *parser) block(what string, item func()) {
+	if !p.expect(tLBrace, what) {
+		return
+	}
+	for !p.is(tRBrace) {
+		if p.is(tEOF) {
+			p.errorf("unexpected end of file, missing } of %v", what)
+			return
+		}
+		start := p.tok.off
+		item()
+		p.progress(start)
+	}
+	p.next()
+}
+
+// expr reads a maths statement, which must start on line
+func (p *parser) expr(line int, stopComma, stopArrow bool, what string) *ast.Expr {
+	if p.tok.pos.Line != line || p.is(tEOF) || p.is(tRBrace) {
+		p.errorf("%v expects a maths statement", what)
+		return nil
+	}
+	p.lex.seek(p.tok)
+	src, pos := p.lex.rawExpr(stopComma, stopArrow)
+	p.next()
+	if src == "" {
+		p.errorAt(pos, "", "%v expects a maths statement", what)
+		return nil
+	}
+	return &ast.Expr{Pos: pos, Src: src}
+}
+
+func (p *parser) parseDecl() ast.Decl {
+	line := p.tok.pos.Line
+	var d ast.Decl
+	switch {
+	case p.isWord("global"):
+		d = p.parseGlobal()
+	case p.isWord("default"):
+		d = p.parseDefault()
+	case p.isWord("preload"):
+		d = p.parsePreload()
+	case p.isWord("palette"):
+		d = p.parsePalette()
+	case p.isWord("world"):
+		d = p.parseWorld()
+	case p.isWord("atom"):
+		d = p.parseAtom()
+	case p.isWord("ruleset"):
+		d = p.parseRuleset()
+	case p.isWord("import"):
+		d = p.parseImport()
+	default:
+		p.errorf("unexpected %v, expected atom, ruleset, world, global, default, preload, palette or import", describe(p.tok))
+	}
+	if d == nil {
+		p.skipLine(line)
+	}
+	return d
+}
+
+func describe(t token) string {
+	if t.kind == tIdent || t.kind == tNumber {
+		return t.text
+	}
+	return tokenNames[t.kind]
+}
+
+func (p *parser) parseImport() ast.Decl {
+	d := &ast.ImportDecl{Pos: p.tok.pos}
+	p.next()
+	if !p.is(tString) {
+		p.errorf("import expects a path in quotes")
+		return nil
+	}
+	d.Path = strings.Trim(p.tok.text, `"`)
+	p.next()
+	if p.isWord("as") {
+		p.next()
+		var ok bool
+		if d.As, ok = p.ident("import as"); !ok {
+			return nil
+		}
+	}
+	return d
+}
+
+func (p *parser) parseGlobal() ast.Decl {
+	d := &ast.GlobalDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Symbol, ok = p.ident("global"); !ok {
+		return nil
+	}
+	if d.Set, ok = p.parseSet("global"); !ok {
+		return nil
+	}
+	return d
+}
+
+func (p *parser) parseSet(what string) ([]ast.SetItem, bool) {
+	if !p.is(tLAngle) {
+		p.errorf("%v expects a set <Name1, Name2, ...>", what)
+		return nil, false
+	}
+	p.next()
+	var items []ast.SetItem
+	for !p.is(tRAngle) {
+		item := ast.SetItem{Pos: p.tok.pos}
+		if p.is(tCaret) {
+			item.Alias = true
+			p.next()
+		}
+		name, ok := p.ident("set")
+		if !ok {
+			return nil, false
+		}
+		item.Name = name
+		items = append(items, item)
+		if p.is(tComma) {
+			p.next()
+		} else if !p.is(tRAngle) {
+			p.errorf("set expects , or >")
+			return nil, false
+		}
+	}
+	p.next()
+	return items, true
+}
+
+func (p *parser) parseDefault() ast.Decl {
+	d := &ast.DefaultDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("default"); !ok {
+		return nil
+	}
+	if d.Value, ok = p.number("default"); !ok {
+		return nil
+	}
+	return d
+}
+
+func (p *parser) parsePreload() ast.Decl {
+	d := &ast.PreloadDecl{Pos: p.tok.pos}
+	line := p.tok.pos.Line
+	p.next()
+	var ok bool
+	if d.From, ok = p.color("preload"); !ok {
+		return nil
+	}
+	d.To = d.From
+	if p.is(tColor) && p.tok.pos.Line == line {
+		if d.To, ok = p.color("preload"); !ok {
+			return nil
+		}
+	}
+	return d
+}
+
+func (p *parser) parsePalette() ast.Decl {
+	d := &ast.PaletteDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Color, ok = p.color("palette"); !ok {
+		return nil
+	}
+	if d.Atom, ok = p.ident("palette"); !ok {
+		return nil
+	}
+	return d
+}
+
+func (p *parser) parseWorld() ast.Decl {
+	d := &ast.WorldDecl{Pos: p.tok.pos}
+	p.next()
+	p.block("world", func() {
+		line := p.tok.pos.Line
+		if it := p.parseWorldItem(); it != nil {
+			d.Items = append(d.Items, it)
+		} else {
+			p.skipLine(line)
+		}
+	})
+	return d
+}
+
+func (p *parser) parseWorldItem() *ast.WorldItem {
+	it := &ast.WorldItem{Pos: p.tok.pos, Kind: p.tok.text, W: 1, H: 1}
+	var ok bool
+	switch {
+	case p.isWord(ast.WorldFill):
+		p.next()
+		const what = "fill rect([x], [y], [w], [h]) [atom]"
+		if !p.expectWord("rect", what) || !p.expect(tLParen, what) {
+			return nil
+		}
+		for i, v := range []*int{&it.X, &it.Y, &it.W, &it.H} {
+			if *v, ok = p.integer(what); !ok {
+				return nil
+			}
+			if i < 3 && !p.expect(tComma, what) {
+				return nil
+			}
+		}
+		if !p.expect(tRParen, what) {
+			return nil
+		}
+		if it.Atom, ok = p.ident(what); !ok {
+			return nil
+		}
+	case p.isWord(ast.WorldPlace):
+		p.next()
+		if it.X, it.Y, ok = p.coord("place ([x], [y]) [atom]"); !ok {
+			return nil
+		}
+		if it.Atom, ok = p.ident("place ([x], [y]) [atom]"); !ok {
+			return nil
+		}
+	case p.isWord(ast.WorldAscii):
+		p.next()
+		if !p.expectWord("at", "ascii at ([x], [y])") {
+			return nil
+		}
+		if it.X, it.Y, ok = p.coord("ascii at ([x], [y])"); !ok {
+			return nil
+		}
+		if !p.expect(tLBrace, "ascii") {
+			return nil
+		}
+		for !p.is(tRBrace) {
+			if p.is(tEOF) {
+				p.errorf("unexpected end of file, missing } of ascii")
+				return nil
+			}
+			p.lex.seek(p.tok)
+			cells, pos := p.lex.rawRow()
+			it.Rows = append(it.Rows, &ast.PatternRow{Pos: pos, Cells: cells})
+			p.next()
+		}
+		p.next()
+	default:
+		p.errorf("unexpected %v, expected fill, place or ascii", describe(p.tok))
+		return nil
+	}
+	return it
+}
+
+func (p *parser) parseAtom() ast.Decl {
+	d := &ast.AtomDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("atom"); !ok {
+		return nil
+	}
+	if p.isWord("alias") {
+		p.next()
+		if d.Alias, ok = p.ident("alias"); !ok {
+			return nil
+		}
+	}
+	p.block("atom "+d.Name, func() {
+		if !p.isWord("section") {
+			p.errorf("unexpected %v in atom %v, expected section", describe(p.tok), d.Name)
+			p.skipLine(p.tok.pos.Line)
+			return
+		}
+		if s := p.parseSection(); s != nil {
+			d.Sections = append(d.Sections, s)
+		}
+	})
+	return d
+}
+
+func (p *parser) parseRuleset() ast.Decl {
+	d := &ast.RulesetDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("ruleset"); !ok {
+		return nil
+	}
+	p.block("ruleset "+d.Name, func() {
+		if r := p.parseRuleItem(); r != nil {
+			d.Rules = append(d.Rules, r)
+		}
+	})
+	return d
+}
+
+func (p *parser) parseSection() *ast.Section {
+	s := &ast.Section{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if s.Kind, ok = p.ident("section"); !ok {
+		p.skipLine(s.Line)
+		return nil
+	}
+
+	var item func()
+	switch s.Kind {
+	case "property":
+		item = func() {
+			if pd := p.parseProp(); pd != nil {
+				s.Props = append(s.Props, pd)
+			}
+		}
+	case "definition":
+		item = func() {
+			if sd := p.parseSetDecl(); sd != nil {
+				s.Defs = append(s.Defs, sd)
+			}
+		}
+	case "update":
+		item = func() {
+			if r := p.parseRuleItem(); r != nil {
+				s.Rules = append(s.Rules, r)
+			}
+		}
+	case "init":
+		item = func() {
+			if !p.isWord("set") && !p.isWord("log") {
+				p.errorf("only set and log are allowed in an init section")
+				p.skipLine(p.tok.pos.Line)
+				return
+			}
+			if st := p.parseStep(); st != nil {
+				s.Init = append(s.Init, st)
+			}
+		}
+	case "color":
+		item = func() {
+			if p.isWord("log") {
+				if st := p.parseStep(); st != nil {
+					s.Colors = append(s.Colors, &ast.ColorLine{Pos: st.Pos, Log: st})
+				}
+				return
+			}
+			if c := p.parseColorLine(); c != nil {
+				s.Colors = append(s.Colors, c)
+			}
+		}
+	default:
+		p.errorAt(s.Pos, s.Kind, "unknown section %v", s.Kind)
+		item = func() {
+			p.skipLine(p.tok.pos.Line)
+		}
+	}
+	p.block("section "+s.Kind, item)
+	return s
+}
+
+func (p *parser) parseProp() *ast.PropDecl {
+	line := p.tok.pos.Line
+	if !p.isWord("def") && !p.isWord("cdef") {
+		p.errorf("unexpected %v in property section, expected def or cdef", describe(p.tok))
+		p.skipLine(line)
+		return nil
+	}
+	d := &ast.PropDecl{Pos: p.tok.pos, Const: p.tok.text == "cdef"}
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("property"); !ok {
+		p.skipLine(line)
+		return nil
+	}
+	switch {
+	case d.Name == "color" && p.isWord("dynamic"):
+		d.Dynamic = true
+		p.next()
+	case d.Name == "color":
+		d.Color, ok = p.color("property color")
+	case d.Name == "key":
+		var key string
+		if key, ok = p.ident("property key"); ok {
+			if r := []rune(key); len(r) == 1 {
+				d.Key = r[0]
+			} else {
+				p.errorAt(d.Pos, key, "key must be a single character")
+				ok = false
+			}
+		}
+	default:
+		d.Value, ok = p.number("property " + d.Name)
+	}
+	if !ok {
+		p.skipLine(line)
+		return nil
+	}
+	return d
+}
+
+func (p *parser) parseSetDecl() *ast.SetDecl {
+	line := p.tok.pos.Line
+	if !p.isWord("def") {
+		p.errorf("unexpected %v in definition section, expected def", describe(p.tok))
+		p.skipLine(line)
+		return nil
+	}
+	d := &ast.SetDecl{Pos: p.tok.pos}
+	p.next()
+	var ok bool
+	if d.Symbol, ok = p.ident("definition"); !ok {
+		p.skipLine(line)
+		return nil
+	}
+	if d.Set, ok = p.parseSet("definition"); !ok {
+		p.skipLine(line)
+		return nil
+	}
+	return d
+}
+
+func (p *parser) parseColorLine() *ast.ColorLine {
+	line := p.tok.pos.Line
+	c := &ast.ColorLine{Pos: p.tok.pos}
+	if c.Cond = p.expr(line, false, true, "color rule"); c.Cond == nil {
+		p.skipLine(line)
+		return nil
+	}
+	if !p.expect(tFatArrow, "color rule") {
+		p.skipLine(line)
+		return nil
+	}
+	comps := []**ast.Expr{&c.R, &c.G, &c.B}
+	for i, comp := range comps {
+		if *comp = p.expr(line, true, false, "color rule"); *comp == nil {
+			p.skipLine(line)
+			return nil
+		}
+		if i < len(comps)-1 && !p.expect(tComma, "color rule [red], [green], [blue]") {
+			p.skipLine(line)
+			return nil
+		}
+	}
+	return c
+}
+
+func (p *parser) parseRuleItem() ast.RuleItem {
+	line := p.tok.pos.Line
+	var r ast.RuleItem
+	switch {
+	case p.isWord("match"):
+		r = p.parseRule()
+	case p.isWord("self"):
+		r = p.parseSelfRule()
+	case p.isWord("repeat"):
+		pos := p.tok.pos
+		p.next()
+		if p.isWord("effect") {
+			p.errorf("repeat effect must follow a match block")
+		} else if p.expectWord("match", "repeat") {
+			r = p.parseRuleEffect(&ast.RuleDecl{Pos: pos, RepeatMatch: true})
+		}
+	case p.isWord("inherit"):
+		r = p.parseInherit()
+	case p.isWord("ext"):
+		r = p.parseExt()
+	default:
+		p.errorf("unexpected %v, expected match, self, repeat match, inherit or ext", describe(p.tok))
+	}
+	if r == nil {
+		p.skipLine(line)
+		// a broken match takes its effect with it
+		if p.is(tArrow) {
+			p.skipLine(p.tok.pos.Line)
+		}
+	}
+	return r
+}
+
+func (p *parser) parseRule() ast.RuleItem {
+	r := &ast.RuleDecl{Pos: p.tok.pos}
+	h := &ast.MatchHeader{Pos: p.tok.pos}
+	p.next()
+	if !p.expect(tLParen, "match header (ox, oy, w, h)") {
+		return nil
+	}
+	for i, v := range []*int{&h.Ox, &h.Oy, &h.W, &h.H} {
+		var ok bool
+		if *v, ok = p.integer("match header (ox, oy, w, h)"); !ok {
+			return nil
+		}
+		if i < 3 && !p.expect(tComma, "match header (ox, oy, w, h)") {
+			return nil
+		}
+	}
+	if !p.expect(tRParen, "match header (ox, oy, w, h)") {
+		return nil
+	}
+	if p.isWord("sym") {
+		p.next()
+		if !p.expect(tLParen, "symmetry sym(x), sym(y) or sym(xy)") {
+			return nil
+		}
+		switch {
+		case p.isWord("x"):
+			h.XSym = true
+		case p.isWord("y"):
+			h.YSym = true
+		case p.isWord("xy") || p.isWord("yx"):
+			h.XSym, h.YSym = true, true
+		default:
+			p.errorf("symmetry expects sym(x), sym(y) or sym(xy)")
+			return nil
+		}
+		p.next()
+		if !p.expect(tRParen, "symmetry sym(x), sym(y) or sym(xy)") {
+			return nil
+		}
+	}
+	r.Header = h
+
+	p.block("match", func() {
+		line := p.tok.pos.Line
+		switch {
+		case p.isWord("eval"):
+			p.next()
+			if e := p.expr(line, false, false, "eval"); e != nil {
+				r.Conds = append(r.Conds, e)
+			}
+		case p.isWord("pattern"):
+			if r.Match != nil {
+				p.errorf("match block already has a pattern")
+			}
+			r.Match = p.parsePattern()
+		default:
+			p.errorf("unexpected %v in match block, expected eval or pattern", describe(p.tok))
+			p.skipLine(line)
+		}
+	})
+	return p.parseRuleEffect(r)
+}
+
+// parseSelfRule parses a rule without match cells, `self -> {...}` or with conditions `self { eval ... } -> {...}`
+func (p *parser) parseSelfRule() ast.RuleItem {
+	r := &ast.RuleDecl{Pos: p.tok.pos, Self: true, Header: &ast.MatchHeader{Pos: p.tok.pos}}
+	p.next()
+	if !p.is(tLBrace) {
+		return p.parseRuleEffect(r)
+	}
+	p.block("self", func() {
+		line := p.tok.pos.Line
+		if p.isWord("eval") {
+			p.next()
+			if e := p.expr(line, false, false, "eval"); e != nil {
+				r.Conds = append(r.Conds, e)
+			}
+			return
+		}
+		p.errorf("unexpected %v in self block, expected eval", describe(p.tok))
+		p.skipLine(line)
+	})
+	return p.parseRuleEffect(r)
+}
+
+// parseRuleEffect parses the `-> {...}` or `repeat effect` after a match
+func (p *parser) parseRuleEffect(r *ast.RuleDecl) ast.RuleItem {
+	r.EffectPos = p.tok.pos
+	if p.isWord("repeat") {
+		p.next()
+		if !p.expectWord("effect", "repeat") {
+			return nil
+		}
+		r.RepeatEffect = true
+		return r
+	}
+	if !p.expect(tArrow, "match block must be followed by an effect, it") {
+		return nil
+	}
+	r.Prob = 1
+	if p.isWord("P") {
+		p.next()
+		if !p.expect(tMinus, "probability P-[probability]") {
+			return nil
+		}
+		var ok bool
+		if r.Prob, ok = p.number("probability P-[probability]"); !ok {
+			return nil
+		}
+		r.HasProb = true
+	}
+	p.block("effect", func() {
+		if st := p.parseStep(); st != nil {
+			r.Steps = append(r.Steps, st)
+		}
+	})
+	return r
+}
+
+func (p *parser) parsePattern() *ast.Pattern {
+	pat := &ast.Pattern{Pos: p.tok.pos}
+	p.next()
+	for !p.is(tEOF) && !p.is(tRBrace) && !(p.is(tIdent) && stepKeywords[p.tok.text]) {
+		p.lex.seek(p.tok)
+		cells, pos := p.lex.rawRow()
+		pat.Rows = append(pat.Rows, &ast.PatternRow{Pos: pos, Cells: cells})
+		p.next()
+	}
+	return pat
+}
+
+func (p *parser) parsePropRef(what string) *ast.PropRef {
+	r := &ast.PropRef{Pos: p.tok.pos}
+	if !p.expect(tLBracket, what+" [property]") {
+		return nil
+	}
+	var ok bool
+	if r.Name, ok = p.ident(what + " [property]"); !ok {
+		return nil
+	}
+	if p.is(tMinus) {
+		p.next()
+		if r.X, ok = p.integer("property coordinate [name-x,y]"); !ok {
+			return nil
+		}
+		if !p.expect(tComma, "property coordinate [name-x,y]") {
+			return nil
+		}
+		if r.Y, ok = p.integer("property coordinate [name-x,y]"); !ok {
+			return nil
+		}
+		r.HasCoord = true
+	} else if p.is(tAt) {
+		p.next()
+		if r.X, ok = p.integer("property coordinate [name@x,y]"); !ok {
+			return nil
+		}
+		if !p.expect(tComma, "property coordinate [name@x,y]") {
+			return nil
+		}
+		if r.Y, ok = p.integer("property coordinate [name@x,y]"); !ok {
+			return nil
+		}
+		r.HasCoord, r.Rel = true, true
+	}
+	if !p.expect(tRBracket, what+" [property]") {
+		return nil
+	}
+	return r
+}
+
+// coord parses `([x], [y])`
+func (p *parser) coord(what string) (int, int, bool) {
+	if !p.expect(tLParen, what) {
+		return 0, 0, false
+	}
+	x, ok := p.integer(what)
+	if !ok || !p.expect(tComma, what) {
+		return 0, 0, false
+	}
+	y, ok := p.integer(what)
+	if !ok || !p.expect(tRParen, what) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+func (p *parser) parseStep() *ast.Step {
+	line := p.tok.pos.Line
+	st := &ast.Step{Pos: p.tok.pos, Op: p.tok.text}
+	if !p.is(tIdent) {
+		p.errorf("unexpected %v, expected a step", describe(p.tok))
+		p.skipLine(line)
+		return nil
+	}
+
+	ok := true
+	switch st.Op {
+	case ast.OpSet, ast.OpInc:
+		p.next()
+		if st.Target = p.parsePropRef(st.Op); st.Target == nil {
+			ok = false
+			break
+		}
+		if st.Op == ast.OpSet {
+			ok = p.expect(tEqual, "set [property] = [maths statement]")
+		} else {
+			ok = p.expectWord("by", "inc [property] by [maths statement]")
+		}
+		if ok {
+			e := p.expr(line, false, false, st.Op)
+			st.Exprs = []*ast.Expr{e}
+			ok = e != nil
+		}
+	case ast.OpClamp:
+		p.next()
+		if st.Target = p.parsePropRef(st.Op); st.Target == nil {
+			ok = false
+			break
+		}
+		if ok = p.expectWord("in", "clamp [property] in [min], [max]"); !ok {
+			break
+		}
+		min := p.expr(line, true, false, "clamp min")
+		if ok = min != nil && p.expect(tComma, "clamp [property] in [min], [max]"); !ok {
+			break
+		}
+		max := p.expr(line, false, false, "clamp max")
+		st.Exprs = []*ast.Expr{min, max}
+		ok = max != nil
+	case ast.OpPick:
+		p.next()
+		if st.Symbol, ok = p.ident("def"); !ok {
+			break
+		}
+		if ok = p.expect(tEqual, "def [symbol] = pick([x], [y])") && p.expectWord("pick", "def [symbol] = pick([x], [y])"); !ok {
+			break
+		}
+		if p.is(tAt) {
+			p.next()
+			st.Rel = true
+		}
+		st.X, st.Y, ok = p.coord("pick([x], [y])")
+	case ast.OpPattern:
+		st.Pattern = p.parsePattern()
+	case ast.OpNonBreak, ast.OpAlwaysRun:
+		p.next()
+	case ast.OpShift:
+		p.next()
+		st.X, st.Y, ok = p.coord("shift([x], [y])")
+	case ast.OpLog:
+		p.next()
+		if !p.is(tString) {
+			p.errorf("log expects a message in quotes")
+			ok = false
+			break
+		}
+		st.Msg = strings.Trim(p.tok.text, `"`)
+		p.next()
+		for ok && p.is(tComma) {
+			p.next()
+			e := p.expr(line, true, false, "log")
+			st.Exprs = append(st.Exprs, e)
+			ok = e != nil
+		}
+	default:
+		p.errorf("unknown step %v", st.Op)
+		ok = false
+	}
+	if !ok {
+		p.skipLine(line)
+		return nil
+	}
+	return st
+}
+
+func (p *parser) parseInherit() ast.RuleItem {
+	d := &ast.InheritDecl{Pos: p.tok.pos}
+	line := p.tok.pos.Line
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("inherit"); !ok {
+		return nil
+	}
+	for p.is(tMinus) && p.tok.pos.Line == line {
+		p.next()
+		flag := p.tok
+		if !p.isWord("P") {
+			p.errorf("unknown inherit modifier %v", describe(flag))
+			return nil
+		}
+		p.next()
+		if !p.expect(tEqual, "inherit modifier -P=[probability]") {
+			return nil
+		}
+		if d.Prob, ok = p.number("inherit modifier -P=[probability]"); !ok {
+			return nil
+		}
+		d.HasProb = true
+	}
+	return d
+}
+
+func (p *parser) parseExt() ast.RuleItem {
+	d := &ast.ExtDecl{Pos: p.tok.pos, Params: make(map[string]string)}
+	p.next()
+	var ok bool
+	if d.Name, ok = p.ident("ext"); !ok {
+		return nil
+	}
+	if !p.expect(tLAngle, "ext [name] <[param]=[value], ...>") {
+		return nil
+	}
+	for !p.is(tRAngle) {
+		line := p.tok.pos.Line
+		name, ok := p.ident("ext parameter")
+		if !ok || !p.expect(tEqual, "ext parameter [param]=[value]") {
+			return nil
+		}
+		if p.tok.pos.Line != line {
+			p.errorf("ext parameter %v expects a value", name)
+			return nil
+		}
+		// values are simple strings such as ~G or 0.5, so read them as raw text
+		p.lex.seek(p.tok)
+		start := p.lex.off
+		for p.lex.off < len(p.lex.src) && p.lex.src[p.lex.off] != ',' && p.lex.src[p.lex.off] != '>' && p.lex.src[p.lex.off] != '\n' {
+			p.lex.advance()
+		}
+		d.Params[name] = strings.TrimSpace(p.lex.src[start:p.lex.off])
+		p.next()
+		if p.is(tComma) {
+			p.next()
+		} else if !p.is(tRAngle) {
+			p.errorf("ext expects , or >")
+			return nil
+		}
+	}
+	p.next()
+	return d
+}
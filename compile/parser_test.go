@@ -0,0 +1,165 @@
+package compile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/compile/ast"
+)
+
+// sourceAt gives the rest of the line of lines at pos
+func sourceAt(lines []string, pos ast.Pos) string {
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	l := lines[pos.Line-1]
+	if pos.Col < 1 || pos.Col > len(l) {
+		return ""
+	}
+	return l[pos.Col-1:]
+}
+
+// written gives the maths statements of f, which are kept as written, and the properties written to, by where they are
+func written(f *ast.File) (exprs map[ast.Pos]string, targets map[ast.Pos]*ast.PropRef) {
+	exprs, targets = make(map[ast.Pos]string), make(map[ast.Pos]*ast.PropRef)
+	steps := func(sts []*ast.Step) {
+		for _, st := range sts {
+			for _, e := range st.Exprs {
+				exprs[e.Pos] = e.Src
+			}
+			if st.Target != nil {
+				targets[st.Target.Pos] = st.Target
+			}
+		}
+	}
+	rules := func(items []ast.RuleItem) {
+		for _, it := range items {
+			if r, ok := it.(*ast.RuleDecl); ok {
+				for _, e := range r.Conds {
+					exprs[e.Pos] = e.Src
+				}
+				steps(r.Steps)
+			}
+		}
+	}
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.AtomDecl:
+			for _, s := range d.Sections {
+				rules(s.Rules)
+				steps(s.Init)
+				for _, c := range s.Colors {
+					if c.Log != nil {
+						steps([]*ast.Step{c.Log})
+						continue
+					}
+					for _, e := range []*ast.Expr{c.Cond, c.R, c.G, c.B} {
+						exprs[e.Pos] = e.Src
+					}
+				}
+			}
+		case *ast.RulesetDecl:
+			rules(d.Rules)
+		}
+	}
+	return exprs, targets
+}
+
+// TestParseRoundTrip parses the shipped scripts and checks that maths statements and the properties set point back at their text
+func TestParseRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../periodicTable/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no scripts: %v", err)
+	}
+	checked := 0
+	for _, name := range files {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := string(b)
+			f, errs := parse(src, name)
+			if len(errs) > 0 {
+				t.Fatalf("parse errors %v", errs)
+			}
+			lines := strings.Split(src, "\n")
+			exprs, targets := written(f)
+			checked += len(exprs) + len(targets)
+			for pos, src := range exprs {
+				if got := sourceAt(lines, pos); !strings.HasPrefix(got, src) {
+					t.Errorf("%v: source has %q, parsed %q", pos, got, src)
+				}
+			}
+			for pos, r := range targets {
+				// spaces are allowed inside the brackets
+				got, _, _ := strings.Cut(sourceAt(lines, pos), "]")
+				if got = strings.ReplaceAll(got, " ", "") + "]"; got != "["+r.String()+"]" {
+					t.Errorf("%v: source has %q, parsed [%v]", pos, got, r)
+				}
+			}
+		})
+	}
+	if checked == 0 {
+		t.Error("no maths statements or properties in the scripts")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{
+			name: "unknown declaration",
+			src:  "atom Empty {\n}\nthing Foo\n",
+			line: 3,
+			col:  1,
+			msg:  "unexpected thing, expected atom, ruleset, world, global, default, preload, palette or import",
+		},
+		{
+			name: "unknown section",
+			src:  "atom Sand alias S {\n    section nope {\n    }\n}\n",
+			line: 2,
+			col:  5,
+			msg:  "unknown section nope",
+		},
+		{
+			name: "short match header",
+			src:  "atom Sand alias S {\n    section update {\n        match (0, 0, 1) {\n        }\n    }\n}\n",
+			line: 3,
+			col:  23,
+			msg:  "match header (ox, oy, w, h) expects ,",
+		},
+		{
+			name: "missing closing brace",
+			src:  "atom Sand alias S {\n    section property {\n        cdef render 1\n",
+			line: 4,
+			col:  1,
+			msg:  "unexpected end of file, missing } of section property",
+		},
+		{
+			name: "bad color",
+			src:  "preload #12345\n",
+			line: 1,
+			col:  9,
+			msg:  "color expects #RRGGBB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parse(tt.src, "test.txt")
+			if len(errs) == 0 {
+				t.Fatal("no errors")
+			}
+			if e := errs[0]; e.Line != tt.line || e.Col != tt.col || e.Msg != tt.msg {
+				t.Errorf("got %v:%v %q, want %v:%v %q", e.Line, e.Col, e.Msg, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package compile
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/compile/ast"
+)
+
+const selfScript = checkEmpty + `
+atom Gas alias G {
+    section property {
+        def temp 0
+    }
+    section update {
+        self -> P-0.5 {
+            inc [temp] by 1
+        }
+        self {
+            eval [temp] > 50
+        }
+        -> {
+            set [temp] = 50
+        }
+    }
+}
+`
+
+func TestParseSelfRule(t *testing.T) {
+	f, errs := parse(selfScript, "test.txt")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	a := f.Decls[1].(*ast.AtomDecl)
+	rules := a.Sections[1].Rules
+	if len(rules) != 2 {
+		t.Fatalf("got %v rules, want 2", len(rules))
+	}
+	for i, line := range []int{12, 15} {
+		r := rules[i].(*ast.RuleDecl)
+		if !r.Self || r.Match != nil || len(r.Conds) != i || r.Line != line {
+			t.Errorf("rule %v: got self %v, match %v, %v conditions at line %v", i, r.Self, r.Match, len(r.Conds), r.Line)
+		}
+	}
+}
+
+func TestLowerSelfRule(t *testing.T) {
+	prog, errs, err := CompileReader(strings.NewReader(selfScript), "test.txt", false)
+	if err != nil || len(errs) > 0 {
+		t.Fatal(err, errs)
+	}
+	rules := prog.Atoms["Gas"].Rules
+	if len(rules) != 2 {
+		t.Fatalf("got %v rules, want 2", len(rules))
+	}
+	for i, r := range rules {
+		if !r.Self || r.NoMatchPattern || r.W != 0 || r.H != 0 || len(r.Match) != 0 || len(r.MatchCon) != i {
+			t.Errorf("rule %v: got %+v", i, r)
+		}
+	}
+	if rules[0].Prob != 0.5 {
+		t.Errorf("got probability %v, want 0.5", rules[0].Prob)
+	}
+}
+
+func TestSelfRuleErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src       string
+		line, col int
+		msg       string
+	}{
+		{`        self {
+            pattern
+            x
+        }
+        -> {
+        }`, 13, 13, "unexpected pattern in self block, expected eval"},
+		{`        self -> {
+            def L = pick(0, 0)
+        }`, 13, 13, "self rule has no cells to pick"},
+	} {
+		src := checkEmpty + `
+atom Gas alias G {
+    section property {
+        def temp 0
+    }
+    section update {
+` + tt.src + `
+    }
+}
+`
+		errs := compileErrors(t, src)
+		if len(errs) == 0 {
+			t.Errorf("%q: no errors", tt.msg)
+			continue
+		}
+		if e := errs[0]; e.Line != tt.line || e.Col != tt.col || e.Msg != tt.msg {
+			t.Errorf("got %v:%v %q, want %v:%v %q", e.Line, e.Col, e.Msg, tt.line, tt.col, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package compile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLowerWorld(t *testing.T) {
+	// the world comes before the atoms it uses, and a second section adds to the first
+	src := `world {
+    fill rect(0, 10, 4, 2) Sand
+    place (2, 3) Sand
+    ascii at (5, 6) {
+        S / Sand
+        _ S /
+    }
+}
+` + checkEmpty + `
+atom Sand alias S {
+    section property {
+        cdef render 1
+    }
+}
+
+world {
+    place (1, 1) Empty
+}
+`
+	prog, errs, err := CompileReader(strings.NewReader(src), "test.txt", false)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("compile: %v %v", err, errs)
+	}
+	want := []Placement{
+		{X: 0, Y: 10, W: 4, H: 2, Atom: "Sand"},
+		{X: 2, Y: 3, W: 1, H: 1, Atom: "Sand"},
+		{X: 5, Y: 6, W: 1, H: 1, Atom: "Sand"},
+		{X: 7, Y: 6, W: 1, H: 1, Atom: "Sand"},
+		{X: 5, Y: 7, W: 1, H: 1, Atom: "Empty"},
+		{X: 6, Y: 7, W: 1, H: 1, Atom: "Sand"},
+		{X: 1, Y: 1, W: 1, H: 1, Atom: "Empty"},
+	}
+	if !slices.Equal(prog.World, want) {
+		t.Errorf("got world\n%+v\nwant\n%+v", prog.World, want)
+	}
+}
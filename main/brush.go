@@ -0,0 +1,133 @@
+//go:build !headless
+
+package main
+
+import (
+	"math/rand"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+type brushShape int
+
+const (
+	squareBrush brushShape = iota
+	circleBrush
+	sprayBrush
+	brushShapes
+)
+
+func (s brushShape) String() string {
+	return [...]string{"square", "circle", "spray"}[s]
+}
+
+var (
+	shape brushShape
+	// size set with the mouse wheel, 0 uses the size cdef of the atom. It is reset when another atom is selected
+	sizeOverride int
+	// chance a cell inside the spray is placed
+	sprayDensity = 0.1
+	// random numbers of the spray, seeded with the seed of the world. Mouse input is not replayed, so runs still differ
+	brushRng *rand.Rand
+	// set while the right button is held down, placing Empty
+	erasing bool
+	// cell of the last placement of the current drag, so that fast drags are joined by a line
+	lastPlaced [2]int
+	dragging   bool
+)
+
+// selectAtom selects the atom placed by clicks
+func selectAtom(atom string) {
+	if atom != selected {
+		sizeOverride = 0
+	}
+	selected = atom
+}
+
+// nextShape switches to the next brush shape
+func nextShape() {
+	shape = (shape + 1) % brushShapes
+}
+
+// scroll changes the brush size with the mouse wheel, or the spray density while shift is held down
+// Over the strip of atoms it scrolls the strip
+func scroll(w *glfw.Window, xoff, yoff float64) {
+	if yoff == 0 {
+		return
+	}
+	if _, posY := w.GetCursorPos(); posY >= float64(gh*scale) {
+		if yoff > 0 {
+			scrollStrip(-1)
+		} else {
+			scrollStrip(1)
+		}
+		return
+	}
+	if shiftDown(w) {
+		if yoff > 0 {
+			sprayDensity = min(1, sprayDensity*1.25)
+		} else {
+			sprayDensity = max(0.01, sprayDensity/1.25)
+		}
+		return
+	}
+	size := brushSize(selected)
+	if yoff > 0 {
+		size++
+	} else {
+		size--
+	}
+	sizeOverride = max(1, size)
+}
+
+func shiftDown(w *glfw.Window) bool {
+	return w.GetKey(glfw.KeyLeftShift) == glfw.Press || w.GetKey(glfw.KeyRightShift) == glfw.Press
+}
+
+// brushCells gives the cells of the brush centred on x, y
+func brushCells(x, y, size int) [][2]int {
+	var cells [][2]int
+	// the centre of an even brush is the top left of its middle four cells
+	lo := -(size - 1) / 2
+	r := float64(size) / 2
+	for dy := lo; dy < lo+size; dy++ {
+		for dx := lo; dx < lo+size; dx++ {
+			if shape != squareBrush {
+				fx, fy := float64(dx-lo)+0.5-r, float64(dy-lo)+0.5-r
+				if fx*fx+fy*fy > r*r {
+					continue
+				}
+			}
+			if shape == sprayBrush && brushRng.Float64() >= sprayDensity {
+				continue
+			}
+			cells = append(cells, [2]int{x + dx, y + dy})
+		}
+	}
+	return cells
+}
+
+// lineCells gives the cells on the line from a to b, without a
+func lineCells(a, b [2]int) [][2]int {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	n := max(abs(dx), abs(dy))
+	var cells [][2]int
+	for i := 1; i <= n; i++ {
+		cells = append(cells, [2]int{a[0] + (dx*i+sign(dx)*n/2)/n, a[1] + (dy*i+sign(dy)*n/2)/n})
+	}
+	return cells
+}
+
+func abs(n int) int {
+	return max(n, -n)
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckExitStatus(t *testing.T) {
+	empty := `atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+`
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"clean", empty, 0},
+		{"only warnings", empty + `
+atom Sand alias E {
+    section definition {
+        def F <Empty>
+    }
+}
+`, 0},
+		{"errors", empty + `
+atom Sand alias S {
+    section update {
+        inherit Nope
+    }
+}
+`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "script.txt")
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := check([]string{path}); got != tt.want {
+				t.Errorf("check exited with %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := check([]string{filepath.Join(t.TempDir(), "missing.txt")}); got != 1 {
+		t.Errorf("check of a missing script exited with %v, want 1", got)
+	}
+}
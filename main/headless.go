@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/sandlang"
+)
+
+// runHeadless runs the world for ticks ticks without a window
+// The final world is written to out, or stdout if out is empty, and statistics to stdout
+// If out ends in .png the world is drawn to it instead
+func runHeadless(ticks int, out string) error {
+	before := world.Updates()
+	var elapsed time.Duration
+	if recorder != nil {
+		recorder.frame(world.Snapshot())
+	}
+	for done := 0; done < ticks; {
+		n := ticks - done
+		if recorder != nil {
+			n = min(n, int(recorder.every))
+		}
+		start := time.Now()
+		world.Step(n)
+		elapsed += time.Since(start)
+		done += n
+		if recorder != nil {
+			recorder.frame(world.Snapshot())
+		}
+	}
+	updates := world.Updates() - before
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(updates) / elapsed.Seconds()
+	}
+	fmt.Printf("%v ticks, %v cell updates in %v (%.0f updates/s), seed %v\n", ticks, updates, elapsed.Round(time.Millisecond), rate, world.Seed())
+	snap := world.Snapshot()
+	w, h := snap.Size()
+	counts := make(map[string]int)
+	for yi := range h {
+		for xi := range w {
+			counts[snap.Atom(xi, yi)]++
+		}
+	}
+	names := make([]string, 0, len(prog.Atoms))
+	for name := range prog.Atoms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%v %c %v\n", name, worldRune(name), counts[name])
+	}
+	for _, c := range inspectCells {
+		writeInspection(os.Stdout, snap, c[0], c[1])
+	}
+
+	if err := recorder.write(); err != nil {
+		return err
+	}
+	if strings.HasSuffix(out, ".png") {
+		return writePNG(out, snap)
+	}
+	if out == "" {
+		fmt.Println()
+		return writeWorld(os.Stdout, snap)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeWorld(f, snap)
+}
+
+// worldRune gives the character a cell of atom is written as, its alias if it is one character
+func worldRune(atom string) rune {
+	if atom == "Empty" {
+		return '.'
+	}
+	if a := []rune(prog.Atoms[atom].Alias); len(a) == 1 {
+		return a[0]
+	}
+	return []rune(atom)[0]
+}
+
+// writeWorld writes a snapshot with one character for each cell
+func writeWorld(w io.Writer, snap *sandlang.Snapshot) error {
+	bw := bufio.NewWriter(w)
+	sw, sh := snap.Size()
+	for yi := range sh {
+		for xi := range sw {
+			bw.WriteRune(worldRune(snap.Atom(xi, yi)))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,116 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/compile"
+	"example.com/sandlang"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// swatch of the selected atom in the top left corner of the window, in pixels
+const (
+	swatchSize   = 16
+	swatchBorder = 2
+)
+
+var (
+	swatchVao, swatchBorderVao uint32
+	// last title set, the title is only set when it changes
+	hudTitle string
+)
+
+func setupHud() {
+	swatchBorderVao, _ = quadVao(pixelQuad(0, 0, swatchSize+2*swatchBorder, swatchSize+2*swatchBorder))
+	swatchVao, _ = quadVao(pixelQuad(swatchBorder, swatchBorder, swatchSize, swatchSize))
+}
+
+// pixelQuad gives the quad of a rectangle of the window given in pixels from the top left
+func pixelQuad(x, y, w, h int) []float32 {
+	ww, wh := float32(gw*scale), float32(windowHeight())
+	l, t := 2*float32(x)/ww-1, 1-2*float32(y)/wh
+	r, b := 2*float32(x+w)/ww-1, 1-2*float32(y+h)/wh
+	return []float32{
+		l, t, 0, 0,
+		l, b, 0, 1,
+		r, b, 1, 1,
+
+		l, t, 0, 0,
+		r, t, 1, 0,
+		r, b, 1, 1,
+	}
+}
+
+// drawHud draws the swatch of the selected atom and shows the rest in the window title
+// The title has the selected atom with its size and dragCD, the frame rate, the rates of updates and rules and if the world is paused
+func drawHud(window *glfw.Window, snap *sandlang.Snapshot) {
+	parts := []string{"Sandlang"}
+	if _, ok := prog.Atoms[selected]; ok {
+		parts = append(parts, fmt.Sprintf("%v %v size %v dragCD %v", selected, shape, brushSize(selected), dragCooldown(selected)))
+		draw(colorTexture(compile.Color{}), swatchBorderVao)
+		draw(colorTexture(swatchColor(selected)), swatchVao)
+	} else {
+		parts = append(parts, "no atom selected")
+	}
+	if shape == sprayBrush {
+		parts = append(parts, fmt.Sprintf("density %.0f%%", sprayDensity*100))
+	}
+	if erasing {
+		parts = append(parts, "erasing")
+	}
+	parts = append(parts, fmt.Sprintf("%.0f fps", frameRate))
+	if world.Paused() {
+		parts = append(parts, "paused")
+	} else {
+		parts = append(parts, fmt.Sprintf("%v updates/s", shortNumber(measuredRate)))
+	}
+	parts = append(parts, fmt.Sprintf("%v rules/s", shortNumber(ruleRate)))
+	if s, ok := stripHover(window); ok {
+		parts = append(parts, s)
+	} else if inspecting {
+		parts = append(parts, inspectHover(window, snap))
+	}
+
+	title := strings.Join(parts, " | ")
+	if title != hudTitle {
+		window.SetTitle(title)
+		hudTitle = title
+	}
+}
+
+// shortNumber writes n with a k or M suffix
+func shortNumber(n float64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", n/1e3)
+	}
+	return fmt.Sprintf("%.0f", n)
+}
+
+// brushSize gives the width and height of the brush placing atom, set with the mouse wheel or from its size cdef
+func brushSize(atom string) int {
+	if sizeOverride > 0 {
+		return sizeOverride
+	}
+	if a, ok := prog.Atoms[atom]; ok {
+		if v, ok := a.ConstProp["size"]; ok {
+			return int(v)
+		}
+	}
+	return 5
+}
+
+// dragCooldown gives the frames between placing atom while the mouse is held down, from its dragCD cdef
+func dragCooldown(atom string) int {
+	if a, ok := prog.Atoms[atom]; ok {
+		if v, ok := a.ConstProp["dragCD"]; ok {
+			return int(v)
+		}
+	}
+	return 5
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"example.com/sandlang"
+)
+
+// cells written after a headless run with -inspect
+var inspectCells [][2]int
+
+// parseCell reads a cell written as x,y
+func parseCell(s string) error {
+	var x, y int
+	if _, err := fmt.Sscanf(s, "%d,%d", &x, &y); err != nil {
+		return fmt.Errorf("cell %q is not x,y", s)
+	}
+	inspectCells = append(inspectCells, [2]int{x, y})
+	return nil
+}
+
+// writeInspection writes the atom, properties, defaults and last rule of the cell at x, y
+func writeInspection(w io.Writer, snap *sandlang.Snapshot, x, y int) {
+	for _, l := range inspectionLines(snap, x, y) {
+		fmt.Fprintln(w, l)
+	}
+}
+
+// inspectionLines gives the atom, properties and last rule of the cell at x, y, a line for each
+func inspectionLines(snap *sandlang.Snapshot, x, y int) []string {
+	sw, sh := snap.Size()
+	if x < 0 || y < 0 || x >= sw || y >= sh {
+		return []string{fmt.Sprintf("cell %v, %v is outside of the %vx%v world", x, y, sw, sh)}
+	}
+	in := snap.Inspect(x, y)
+	lines := []string{fmt.Sprintf("cell %v, %v at tick %v: %v", x, y, snap.Tick(), inspectionTitle(in))}
+	for _, n := range slices.Sorted(maps.Keys(in.Props)) {
+		lines = append(lines, fmt.Sprintf("  prop %v = %v", n, in.Props[n]))
+	}
+	for _, n := range slices.Sorted(maps.Keys(in.ConstProps)) {
+		lines = append(lines, fmt.Sprintf("  cdef %v = %v", n, in.ConstProps[n]))
+	}
+	for _, n := range slices.Sorted(maps.Keys(in.Defaults)) {
+		lines = append(lines, fmt.Sprintf("  default %v = %v", n, in.Defaults[n]))
+	}
+	return lines
+}
+
+// inspectionTitle gives the atom, alias and last rule of a cell on one line
+func inspectionTitle(in sandlang.Inspection) string {
+	var b strings.Builder
+	b.WriteString(in.Atom)
+	if in.Alias != "" {
+		fmt.Fprintf(&b, " (%v)", in.Alias)
+	}
+	if in.LastRule >= 0 {
+		fmt.Fprintf(&b, ", last rule %v", in.LastRule)
+	} else {
+		b.WriteString(", no rule fired")
+	}
+	return b.String()
+}
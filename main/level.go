@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"strings"
+
+	"example.com/sandlang"
+)
+
+// initWorld makes a world the size of the image at path, with each cell the atom of its pixel
+func initWorld(path string, opts []sandlang.Option) (*sandlang.World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", path, err)
+	}
+
+	b := img.Bounds()
+	w, err := sandlang.NewWorld(prog, b.Dx(), b.Dy(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	unknown, err := w.SetImage(img)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		cols := make([]string, len(unknown))
+		for i, c := range unknown {
+			cols[i] = fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+		}
+		fmt.Printf("%v: cells of colors without an atom were left unchanged, add them to the palette: %v\n", path, strings.Join(cols, ", "))
+	}
+	return w, nil
+}
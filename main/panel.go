@@ -0,0 +1,92 @@
+//go:build !headless
+
+package main
+
+import (
+	"image"
+	"image/color"
+	imagedraw "image/draw"
+	"strings"
+
+	"example.com/sandlang"
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// padding around the text of the inspector panel, and its distance from the top of the window, in pixels
+const (
+	panelPad = 4
+	panelTop = swatchSize + 2*swatchBorder + 4
+)
+
+var (
+	// text drawn in the panel, and the side it is on, the texture is only drawn again when they change
+	panelText string
+	panelLeft bool
+	panelTex  uint32
+	panelVao  uint32
+	panelVbo  uint32
+)
+
+// drawInspector draws a panel with the atom, properties and last rule of the cell under the cursor
+// The panel is on the right of the world, or on the left when the cursor is on the right half
+func drawInspector(window *glfw.Window, snap *sandlang.Snapshot) {
+	x, y := cursorCell(window)
+	if x < 0 || y < 0 || x >= gw || y >= gh {
+		return
+	}
+	text := strings.Join(inspectionLines(snap, x, y), "\n")
+	left := x >= gw/2
+	if text != panelText || left != panelLeft || panelTex == 0 {
+		panelText, panelLeft = text, left
+		img := textImage(strings.Split(text, "\n"))
+		updatePanel(img, left)
+	}
+	draw(panelTex, panelVao)
+}
+
+// textImage draws lines in white on black
+func textImage(lines []string) *image.RGBA {
+	face := basicfont.Face7x13
+	w := 0
+	for _, l := range lines {
+		w = max(w, font.MeasureString(face, l).Ceil())
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w+2*panelPad, len(lines)*face.Height+2*panelPad))
+	imagedraw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{A: 255}), image.Point{}, imagedraw.Src)
+	d := font.Drawer{Dst: img, Src: image.White, Face: face}
+	for i, l := range lines {
+		d.Dot = fixed.P(panelPad, panelPad+face.Ascent+i*face.Height)
+		d.DrawString(l)
+	}
+	return img
+}
+
+// updatePanel uploads img as the texture of the panel and makes its quad
+func updatePanel(img *image.RGBA, left bool) {
+	if panelTex == 0 {
+		gl.GenTextures(1, &panelTex)
+		gl.BindTexture(gl.TEXTURE_2D, panelTex)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		// nearest keeps the text sharp
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	}
+	b := img.Bounds()
+	gl.BindTexture(gl.TEXTURE_2D, panelTex)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(b.Dx()), int32(b.Dy()), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+
+	if panelVao != 0 {
+		deleteQuad(panelVao, panelVbo)
+	}
+	x := max(0, gw*scale-b.Dx()-panelPad)
+	if left {
+		x = panelPad
+	}
+	panelVao, panelVbo = quadVao(pixelQuad(x, panelTop, b.Dx(), b.Dy()))
+}
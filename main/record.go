@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	imagedraw "image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"time"
+
+	"example.com/sandlang"
+)
+
+// pixels of a cell in screenshots and recordings, set by the run flags
+var imageScale = 1
+
+// recording collects a frame of the world every few ticks and writes them as a gif
+type recording struct {
+	path  string
+	every uint64
+	// tick the next frame is taken at
+	next uint64
+	gif  gif.GIF
+}
+
+var recorder *recording
+
+func newRecording(path string, every int) *recording {
+	return &recording{path: path, every: uint64(max(1, every))}
+}
+
+// frame adds the snapshot if it is time for the next frame
+func (r *recording) frame(snap *sandlang.Snapshot) {
+	if r == nil || snap.Tick() < r.next {
+		return
+	}
+	r.next = snap.Tick() + r.every
+	r.gif.Image = append(r.gif.Image, paletted(snap.Image(imageScale)))
+	r.gif.Delay = append(r.gif.Delay, 5)
+}
+
+// write writes the frames recorded so far
+func (r *recording) write() error {
+	if r == nil || len(r.gif.Image) == 0 {
+		return nil
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, &r.gif); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("recorded %v frames to %v\n", len(r.gif.Image), r.path)
+	return nil
+}
+
+// paletted converts an image to the colors in it, or to the closest plan9 colors if there are more than a gif can have
+func paletted(img *image.RGBA) *image.Paletted {
+	var pal color.Palette
+	seen := make(map[color.RGBA]bool)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y && len(pal) <= 256; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := img.RGBAAt(x, y); !seen[c] {
+				seen[c] = true
+				pal = append(pal, c)
+			}
+		}
+	}
+	if len(pal) > 256 {
+		pal = palette.Plan9
+	}
+	p := image.NewPaletted(b, pal)
+	imagedraw.Draw(p, b, img, b.Min, imagedraw.Src)
+	return p
+}
+
+// writePNG writes the snapshot as a png
+func writePNG(path string, snap *sandlang.Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, snap.Image(imageScale)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// screenshot writes the world to a png named after the time
+func screenshot() error {
+	path := time.Now().Format("screenshot-20060102-150405.png")
+	if err := writePNG(path, world.Snapshot()); err != nil {
+		return err
+	}
+	fmt.Println("saved", path)
+	return nil
+}
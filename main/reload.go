@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/compile"
+)
+
+// script run by the world, compiled again by reloadScript
+var scriptPath = compile.DefaultScript
+
+// reloadScript compiles the script again and switches the world to it
+// If the script has errors they are printed and the world keeps running the old one
+func reloadScript() error {
+	p, errs, err := compile.CompileFile(scriptPath, false)
+	if err != nil {
+		return fmt.Errorf("failed to reload script: %v", err)
+	}
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+	if n, _ := compile.CountErrors(errs); n > 0 {
+		return fmt.Errorf("failed to reload script: %v errors, still running the old one", n)
+	}
+	report, err := world.Reload(p)
+	if err != nil {
+		return fmt.Errorf("failed to reload script: %v", err)
+	}
+	prog = p
+	fmt.Println("reloaded", scriptPath)
+	if len(report.Dropped) > 0 {
+		fmt.Printf("atoms no longer in the script became Empty: %v\n", strings.Join(report.Dropped, ", "))
+	}
+	return nil
+}
+
+// watchScript checks the files of the running script every interval, and sends when one of them changed
+func watchScript(interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		mtimes := make(map[string]time.Time)
+		for {
+			time.Sleep(interval)
+			modified := false
+			for _, f := range world.Program().Files {
+				info, err := os.Stat(f)
+				if err != nil {
+					continue
+				}
+				if t, ok := mtimes[f]; ok && !info.ModTime().Equal(t) {
+					modified = true
+				}
+				mtimes[f] = info.ModTime()
+			}
+			if modified {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,502 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"example.com/compile"
+	"example.com/sandlang"
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Vertex Shader
+var vertexShaderSource = `
+#version 410
+layout(location = 0) in vec2 position;
+layout(location = 1) in vec2 texCoord;
+
+out vec2 TexCoord;
+
+void main() {
+    gl_Position = vec4(position, 0.0, 1.0);
+    TexCoord = texCoord;
+}
+` + "\x00"
+
+// Fragment Shader
+var fragmentShaderSource = `
+#version 410
+in vec2 TexCoord;
+out vec4 color;
+
+uniform sampler2D ourTexture;
+
+void main() {
+    color = texture(ourTexture, TexCoord);
+}
+` + "\x00"
+
+// cellSize gives the width and height of a cell in clip space
+func cellSize() (float32, float32) {
+	return 2 / float32(gw), 2 * float32(scale) / float32(windowHeight())
+}
+
+// quadVertices gives the quad of the top left cell
+func quadVertices() []float32 {
+	cw, ch := cellSize()
+	return []float32{
+		-1, 1, 0, 0,
+		-1, 1 - ch, 0, 1,
+		-1 + cw, 1 - ch, 1, 1,
+
+		-1, 1, 0, 0,
+		-1 + cw, 1, 1, 0,
+		-1 + cw, 1 - ch, 1, 1,
+	}
+}
+
+var program uint32
+
+var colorCache = make(map[compile.Color]uint32)
+
+var tryPlaceCoolDown = 0
+
+// atom placed by each key
+var placeKeys = make(map[rune]string)
+
+// vao of each cell
+var vaos [][]uint32
+
+func init() {
+	// Lock OS thread to ensure OpenGL context works
+	runtime.LockOSThread()
+}
+
+// runWindow opens the window and runs the simulation until it is closed, reloading the script when it changes if watch is set
+func runWindow(watch bool) error {
+	// Initialize GLFW
+	if err := glfw.Init(); err != nil {
+		return fmt.Errorf("failed to initialize glfw: %v", err)
+	}
+	defer glfw.Terminate()
+
+	// Configure GLFW
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+
+	// Create GLFW Window
+	window, err := glfw.CreateWindow(gw*scale, windowHeight(), "Sandlang", nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	window.MakeContextCurrent()
+
+	// Initialize OpenGL
+	if err := gl.Init(); err != nil {
+		panic(err)
+	}
+	// fmt.Println("OpenGL version", gl.GoStr(gl.GetString(gl.VERSION)))
+
+	// Compile shaders and create program
+	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+	if err != nil {
+		panic(err)
+	}
+	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+	if err != nil {
+		panic(err)
+	}
+
+	program = gl.CreateProgram()
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
+	gl.LinkProgram(program)
+	gl.UseProgram(program)
+
+	setupKeys()
+	for _, v := range prog.Atoms {
+		colorCache[v.Color] = generateColorTexture(v.Color.R, v.Color.G, v.Color.B)
+	}
+
+	// fmt.Println(prog)
+
+	for _, p := range prog.Preload {
+		for r := uint16(p[0].R); r <= uint16(p[1].R); r++ {
+			for g := uint16(p[0].G); g <= uint16(p[1].G); g++ {
+				for b := uint16(p[0].B); b <= uint16(p[1].B); b++ {
+					// fmt.Println(r, g, b)
+					colorCache[compile.Color{R: uint8(r), G: uint8(g), B: uint8(b)}] = generateColorTexture(uint8(r), uint8(g), uint8(b))
+				}
+			}
+		}
+	}
+
+	// fmt.Println(colorCache)
+	setupHud()
+	setupStrip()
+
+	vaos = make([][]uint32, gh)
+	for yi := range vaos {
+		vaos[yi] = make([]uint32, gw)
+		for xi := range vaos[yi] {
+			vaos[yi][xi] = genVao(uint16(xi), uint16(yi))
+		}
+	}
+
+	var changed <-chan struct{}
+	if watch {
+		changed = watchScript(500 * time.Millisecond)
+	}
+	brushRng = rand.New(rand.NewSource(world.Seed()))
+	world.Start()
+
+	window.SetMouseButtonCallback(click)
+	window.SetCharCallback(keyPress)
+	window.SetKeyCallback(keyEvent)
+	window.SetScrollCallback(scroll)
+
+	// Render Loop
+	for !window.ShouldClose() {
+		// Clear screen and draw the texture
+		// s := time.Now()
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+
+		// s := time.Now()
+		snap := world.Snapshot()
+		recorder.frame(snap)
+		drawAll(snap)
+		drawStrip()
+		if inspecting {
+			drawInspector(window, snap)
+		}
+		measureRate()
+		drawHud(window, snap)
+		// fmt.Println(time.Since(s))
+
+		window.SwapBuffers()
+		glfw.PollEvents()
+
+		select {
+		case <-changed:
+			reload()
+		default:
+		}
+
+		if keyDown || erasing {
+			tryPlaceCoolDown++
+			if tryPlaceCoolDown >= dragCooldown(selected) {
+				tryPlace(window)
+				tryPlaceCoolDown = 0
+			}
+		}
+		// fmt.Println(time.Since(s))
+		// time.Sleep(1000 / 60 * time.Millisecond)
+	}
+
+	world.Stop()
+	return recorder.write()
+}
+
+// atom placed by clicks, empty if none is selected
+var selected string
+
+// setupKeys maps the keys of the atoms of prog to the atoms
+func setupKeys() {
+	clear(placeKeys)
+	for name, v := range prog.Atoms {
+		if v.Key != ' ' {
+			placeKeys[v.Key] = name
+		}
+	}
+}
+
+// reload reloads the script, keeping the old one if it fails
+func reload() {
+	if err := reloadScript(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	setupKeys()
+	setupStrip()
+}
+
+func keyPress(window *glfw.Window, char rune) {
+	if char == ' ' {
+		// pauses in keyEvent
+		return
+	}
+	if char == '/' {
+		world.Fill("Empty")
+	} else {
+		selectAtom(placeKeys[char])
+	}
+	// fmt.Println(selected)
+}
+
+// keyEvent handles keys that do not type a character
+// F2 takes a screenshot, F5 saves the world, F6 reloads the script and F9 loads the world
+// F3 turns the inspector on and off, tab switches the brush shape
+// Space pauses, right runs one tick while paused, up and down double and halve the speed
+func keyEvent(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action == glfw.Repeat && key == glfw.KeyRight {
+		stepTick()
+		return
+	}
+	if action != glfw.Press {
+		return
+	}
+	var err error
+	switch key {
+	case glfw.KeySpace:
+		togglePause()
+	case glfw.KeyRight:
+		stepTick()
+	case glfw.KeyUp:
+		changeRate(2)
+	case glfw.KeyDown:
+		changeRate(0.5)
+	case glfw.KeyF2:
+		err = screenshot()
+	case glfw.KeyF3:
+		toggleInspector()
+	case glfw.KeyTab:
+		nextShape()
+	case glfw.KeyF6:
+		reload()
+	case glfw.KeyF5:
+		err = saveSnapshot()
+	case glfw.KeyF9:
+		err = loadSnapshot()
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// inspecting is set while the inspector panel is shown, clicks write the cell under the cursor instead of placing atoms
+var inspecting bool
+
+func toggleInspector() {
+	inspecting = !inspecting
+	keyDown = false
+}
+
+// inspectHover gives the cell under the cursor for the window title
+func inspectHover(window *glfw.Window, snap *sandlang.Snapshot) string {
+	x, y := cursorCell(window)
+	if x < 0 || y < 0 || x >= gw || y >= gh {
+		return "inspector"
+	}
+	return fmt.Sprintf("inspector: %v, %v %v", x, y, inspectionTitle(snap.Inspect(x, y)))
+}
+
+// cursorCell gives the cell under the cursor, which can be outside of the world
+func cursorCell(w *glfw.Window) (int, int) {
+	posX, posY := w.GetCursorPos()
+	if posX < 0 || posY < 0 {
+		return -1, -1
+	}
+	return int(posX) / scale, int(posY) / scale
+}
+
+// var testUpdateX, testUpdateY int
+var keyDown bool
+
+func click(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mod glfw.ModifierKey) {
+	if atom, ok := stripAt(w); ok {
+		if button == glfw.MouseButton1 && action == glfw.Press {
+			selectAtom(atom)
+		}
+		return
+	}
+	if inspecting {
+		if button == glfw.MouseButton1 && action == glfw.Press {
+			x, y := cursorCell(w)
+			writeInspection(os.Stdout, world.Snapshot(), x, y)
+		}
+		return
+	}
+	if button == glfw.MouseButton1 && action == glfw.Press {
+		keyDown = true
+	} else if button == glfw.MouseButton1 && action == glfw.Release {
+		keyDown = false
+	} else if button == glfw.MouseButton2 {
+		erasing = action == glfw.Press
+	}
+	if !keyDown && !erasing {
+		dragging = false
+	}
+}
+
+// tryPlace places the selected atom with the brush centred on the cursor, or Empty while the right button is held down
+// Only Empty cells are replaced, unless erasing or shift is held down
+func tryPlace(w *glfw.Window) {
+	newT := selected
+	overwrite := shiftDown(w)
+	if erasing {
+		newT, overwrite = "Empty", true
+	}
+	if _, ok := prog.Atoms[newT]; !ok {
+		return
+	}
+
+	boxX, boxY := cursorCell(w)
+	cursor := [2]int{boxX, boxY}
+
+	// testUpdateX = boxX
+	// testUpdateY = boxY
+
+	// fmt.Printf("Cell %+v\n", grid[boxY][boxX])
+	centres := [][2]int{cursor}
+	if dragging && cursor != lastPlaced {
+		centres = lineCells(lastPlaced, cursor)
+	}
+	lastPlaced, dragging = cursor, true
+
+	size := brushSize(selected)
+	done := make(map[[2]int]bool)
+	for _, c := range centres {
+		for _, p := range brushCells(c[0], c[1], size) {
+			if done[p] {
+				continue
+			}
+			done[p] = true
+			if cell, ok := world.Cell(p[0], p[1]); ok && cell.Atom != newT && (overwrite || cell.Atom == "Empty") {
+				world.SetCell(p[0], p[1], newT)
+			}
+		}
+	}
+}
+
+func drawAll(snap *sandlang.Snapshot) {
+	for yi := range vaos {
+		for xi := range vaos[yi] {
+			drawCell(snap, xi, yi)
+		}
+	}
+}
+
+func genVao(x, y uint16) uint32 {
+	points := quadVertices()
+	cw, ch := cellSize()
+
+	for i := range points {
+		switch i % 4 {
+		case 0:
+			points[i] += cw * float32(x)
+		case 1:
+			points[i] -= ch * float32(y)
+		default:
+			continue
+		}
+	}
+
+	vao, _ := quadVao(points)
+	return vao
+}
+
+// quadVao makes the vao of a quad given as 6 vertices of x, y, u, v, and the vbo holding them
+// Quads made again while running must delete both with deleteQuad
+func quadVao(points []float32) (vao, vbo uint32) {
+	// Create VAO and VBO for the full-screen quad
+	gl.GenVertexArrays(1, &vao)
+	gl.GenBuffers(1, &vbo)
+
+	gl.BindVertexArray(vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(points)*4, gl.Ptr(points), gl.STATIC_DRAW)
+
+	// Configure vertex attributes
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(2*4))
+	gl.EnableVertexAttribArray(1)
+
+	return vao, vbo
+}
+
+// deleteQuad deletes the vao and vbo of a quad made by quadVao
+func deleteQuad(vao, vbo uint32) {
+	gl.DeleteVertexArrays(1, &vao)
+	gl.DeleteBuffers(1, &vbo)
+}
+
+func draw(textureID, vao uint32) {
+	// gl.UseProgram(program)
+	// gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, textureID)
+	// gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("ourTexture\x00")), 0)
+
+	gl.BindVertexArray(vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 6)
+}
+
+func drawCell(snap *sandlang.Snapshot, x, y int) {
+	col, ok := snap.Color(x, y)
+	if !ok {
+		return
+	}
+	// fmt.Println(col)
+	draw(colorTexture(col), vaos[y][x])
+}
+
+// colorTexture gives the texture of col, generating it the first time
+func colorTexture(col compile.Color) uint32 {
+	t, ok := colorCache[col]
+	if !ok {
+		t = generateColorTexture(col.R, col.G, col.B)
+		colorCache[col] = t
+	}
+	return t
+}
+
+// Generate a simple 1x1 color texture
+func generateColorTexture(r, g, b uint8) uint32 {
+	colorData := []uint8{r, g, b, 255} // RGBA color
+
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, 1, 1, 0, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&colorData[0]))
+
+	// Set texture parameters
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+
+	return texture
+}
+
+// CompileShader compiles a shader from source code
+func compileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+
+	csources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, csources, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := make([]byte, logLength+1)
+		gl.GetShaderInfoLog(shader, logLength, nil, &log[0])
+
+		return 0, fmt.Errorf("failed to compile %v: %v", source, string(log))
+	}
+
+	return shader, nil
+}
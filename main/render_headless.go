@@ -0,0 +1,10 @@
+//go:build headless
+
+package main
+
+import "errors"
+
+// runWindow is not available without GLFW and OpenGL
+func runWindow(watch bool) error {
+	return errors.New("built without a window, use run --headless")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/sandlang"
+)
+
+// scriptLog writes the output of log steps as one JSON object per line
+// Each atom can only write rate lines a second, the rest are dropped and counted
+type scriptLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// atoms that are logged, all of them if nil
+	atoms map[string]bool
+	rate  int
+	// second the counts are for
+	second  int64
+	written map[string]int
+	dropped map[string]int
+	// time.Now, the tests replace it
+	now func() time.Time
+}
+
+type logEntry struct {
+	sandlang.LogEntry
+	// lines of this atom dropped since the last one written
+	Dropped int `json:"dropped,omitempty"`
+}
+
+var scriptLogger *scriptLog
+
+// newScriptLog makes a log writing to w. atoms is a comma separated list of atom names, or all
+func newScriptLog(w io.Writer, atoms string, rate int) *scriptLog {
+	l := &scriptLog{enc: json.NewEncoder(w), rate: rate, written: make(map[string]int), dropped: make(map[string]int), now: time.Now}
+	if atoms != "all" {
+		l.atoms = make(map[string]bool)
+		for _, a := range strings.Split(atoms, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				l.atoms[a] = true
+			}
+		}
+	}
+	return l
+}
+
+// write writes an entry of a log step, unless its atom is not logged or over the rate
+func (l *scriptLog) write(e sandlang.LogEntry) {
+	if l == nil || l.atoms != nil && !l.atoms[e.Atom] {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := l.now().Unix(); now != l.second {
+		l.second = now
+		clear(l.written)
+	}
+	if l.written[e.Atom] >= l.rate {
+		l.dropped[e.Atom]++
+		return
+	}
+	l.written[e.Atom]++
+
+	l.enc.Encode(logEntry{LogEntry: e, Dropped: l.dropped[e.Atom]})
+	l.dropped[e.Atom] = 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"example.com/sandlang"
+)
+
+func TestScriptLogRate(t *testing.T) {
+	var b bytes.Buffer
+	l := newScriptLog(&b, "Sand", 2)
+	now := time.Unix(100, 0)
+	l.now = func() time.Time { return now }
+
+	for range 5 {
+		l.write(sandlang.LogEntry{Atom: "Sand"})
+	}
+	l.write(sandlang.LogEntry{Atom: "Water"})
+	// the next second lets Sand write again, with the count of the lines dropped
+	now = now.Add(time.Second)
+	l.write(sandlang.LogEntry{Atom: "Sand"})
+
+	var got []logEntry
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var e logEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %v lines, want 3: %+v", len(got), got)
+	}
+	for i, want := range []int{0, 0, 3} {
+		if got[i].Atom != "Sand" || got[i].Dropped != want {
+			t.Errorf("line %v is %v dropping %v, want Sand dropping %v", i, got[i].Atom, got[i].Dropped, want)
+		}
+	}
+}
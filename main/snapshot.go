@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"example.com/sandlang"
+)
+
+// snapshot saved and loaded by the hotkeys
+var savePath = "world.snap"
+
+// loadWorld makes the world from the snapshot at path
+func loadWorld(path string, opts []sandlang.Option) (*sandlang.World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w, report, err := sandlang.LoadWorld(prog, f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %v: %v", path, err)
+	}
+	printReport(path, report)
+	return w, nil
+}
+
+// saveSnapshot writes the world to savePath
+func saveSnapshot() error {
+	f, err := os.Create(savePath)
+	if err != nil {
+		return err
+	}
+	if err := world.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("saved", savePath)
+	return nil
+}
+
+// loadSnapshot replaces the world with the snapshot at savePath
+func loadSnapshot() error {
+	f, err := os.Open(savePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, err := world.Load(f)
+	if err != nil {
+		return fmt.Errorf("failed to load %v: %v", savePath, err)
+	}
+	printReport(savePath, report)
+	return nil
+}
+
+func printReport(path string, report sandlang.LoadReport) {
+	fmt.Println("loaded", path)
+	if report.ScriptChanged {
+		fmt.Println("the script changed since the snapshot was saved, atoms were matched by name")
+	}
+	if len(report.Dropped) > 0 {
+		fmt.Printf("atoms no longer in the script became Empty: %v\n", strings.Join(report.Dropped, ", "))
+	}
+}
@@ -0,0 +1,84 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// cell updates a second measured over the last second, while the world is not paused
+var measuredRate float64
+
+// rules applied and frames drawn a second, measured over the last second
+var ruleRate, frameRate float64
+
+var (
+	lastMeasure time.Time
+	lastUpdates uint64
+	lastApplied uint64
+	frames      int
+)
+
+// measureRate updates the measured rates once a second, it is called every frame
+func measureRate() {
+	now := time.Now()
+	if lastMeasure.IsZero() {
+		lastMeasure, lastUpdates, lastApplied = now, world.Updates(), world.RulesApplied()
+		return
+	}
+	frames++
+	if d := now.Sub(lastMeasure); d >= time.Second {
+		n, applied := world.Updates(), world.RulesApplied()
+		if !world.Paused() {
+			measuredRate = float64(n-lastUpdates) / d.Seconds()
+		}
+		ruleRate = float64(applied-lastApplied) / d.Seconds()
+		frameRate = float64(frames) / d.Seconds()
+		lastMeasure, lastUpdates, lastApplied, frames = now, n, applied, 0
+	}
+}
+
+// togglePause pauses or resumes the world
+func togglePause() {
+	if world.Paused() {
+		world.Resume()
+	} else {
+		world.Pause()
+	}
+	printSpeed()
+}
+
+// stepTick pauses the world and runs one more tick
+func stepTick() {
+	// Pause drops the ticks left from earlier steps, so only pause a running world
+	if !world.Paused() {
+		world.Pause()
+	}
+	world.Advance(1)
+}
+
+// changeRate multiplies the rate of updates by f, starting from the measured rate if it is unlimited
+func changeRate(f float64) {
+	r := world.Rate()
+	if r == 0 {
+		if f > 1 || measuredRate == 0 {
+			return
+		}
+		r = measuredRate
+	}
+	world.SetRate(r * f)
+	printSpeed()
+}
+
+func printSpeed() {
+	state := "running"
+	if world.Paused() {
+		state = "paused"
+	}
+	if r := world.Rate(); r > 0 {
+		fmt.Printf("%v at %.0f updates/s\n", state, r)
+	} else {
+		fmt.Printf("%v as fast as possible\n", state)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"example.com/compile"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// height of the strip of atoms below the world, and narrowest width of an entry, in pixels
+const (
+	stripHeight   = 24
+	stripMinEntry = 12
+)
+
+var (
+	// atoms in the strip, by id
+	stripAtoms []string
+	// background and swatch of each place for an entry, and their vbos
+	stripVaos [][2]uint32
+	stripVbos [][2]uint32
+	// width of an entry in pixels
+	stripEntry int
+	// first atom shown, the strip scrolls with the mouse wheel if the atoms do not fit
+	stripOffset int
+)
+
+// windowHeight gives the height of the window in pixels, the world and the strip below it
+func windowHeight() int {
+	return gh*scale + stripHeight
+}
+
+// setupStrip lists the atoms of prog that can be placed and makes the quads of their entries
+// Atoms are listed if they are rendered or have a key, unless they have cdef hidden 1
+func setupStrip() {
+	for i, v := range stripVaos {
+		deleteQuad(v[0], stripVbos[i][0])
+		deleteQuad(v[1], stripVbos[i][1])
+	}
+	stripAtoms = stripAtoms[:0]
+	for name, a := range prog.Atoms {
+		if a.ConstProp["hidden"] == 1 || (a.ConstProp["render"] != 1 && a.Key == ' ') {
+			continue
+		}
+		stripAtoms = append(stripAtoms, name)
+	}
+	slices.SortFunc(stripAtoms, func(a, b string) int {
+		return int(prog.Atoms[a].Id) - int(prog.Atoms[b].Id)
+	})
+
+	stripVaos, stripVbos = stripVaos[:0], stripVbos[:0]
+	// keep the strip where it was if it still fits after a reload
+	defer scrollStrip(0)
+	if len(stripAtoms) == 0 {
+		return
+	}
+	stripEntry = max(stripMinEntry, min(stripHeight, gw*scale/len(stripAtoms)))
+	shown := min(len(stripAtoms), max(1, gw*scale/stripEntry))
+	for i := range shown {
+		x := i * stripEntry
+		bgVao, bgVbo := quadVao(pixelQuad(x, gh*scale, stripEntry, stripHeight))
+		swVao, swVbo := quadVao(pixelQuad(x+2, gh*scale+2, stripEntry-4, stripHeight-4))
+		stripVaos = append(stripVaos, [2]uint32{bgVao, swVao})
+		stripVbos = append(stripVbos, [2]uint32{bgVbo, swVbo})
+	}
+}
+
+// scrollStrip moves the strip by n entries, keeping it full
+func scrollStrip(n int) {
+	stripOffset = max(0, min(stripOffset+n, len(stripAtoms)-len(stripVaos)))
+}
+
+// drawStrip draws the entries of the strip, the selected one with a white border
+func drawStrip() {
+	for i, vaos := range stripVaos {
+		name := stripAtoms[stripOffset+i]
+		border := compile.Color{}
+		if name == selected {
+			border = compile.Color{R: 255, G: 255, B: 255}
+		}
+		draw(colorTexture(border), vaos[0])
+		draw(colorTexture(swatchColor(name)), vaos[1])
+	}
+}
+
+// stripAt gives the atom of the entry under the cursor, ok is false if it is not over one
+func stripAt(w *glfw.Window) (atom string, ok bool) {
+	posX, posY := w.GetCursorPos()
+	if posY < float64(gh*scale) || posX < 0 || stripEntry == 0 {
+		return "", false
+	}
+	i := int(posX) / stripEntry
+	if i >= len(stripVaos) {
+		return "", false
+	}
+	return stripAtoms[stripOffset+i], true
+}
+
+// stripHover gives the name and key of the entry under the cursor for the window title
+func stripHover(w *glfw.Window) (string, bool) {
+	atom, ok := stripAt(w)
+	if !ok {
+		return "", false
+	}
+	s := fmt.Sprintf("%v no key", atom)
+	if k := prog.Atoms[atom].Key; k != ' ' {
+		s = fmt.Sprintf("%v key %c", atom, k)
+	}
+	if len(stripVaos) < len(stripAtoms) {
+		s += ", scroll for more"
+	}
+	return s, true
+}
+
+// swatchColor gives the color atom is shown with, its palette color if it is not rendered
+func swatchColor(atom string) compile.Color {
+	a := prog.Atoms[atom]
+	if a.ConstProp["render"] == 1 {
+		return a.Color
+	}
+	for col, name := range prog.Palette {
+		if name == atom {
+			return col
+		}
+	}
+	return compile.Color{R: 128, G: 128, B: 128}
+}
@@ -0,0 +1,127 @@
+package sandlang
+
+import (
+	"sync"
+	"time"
+)
+
+// furthest the rate catches up after updates ran late, so a slow stretch is not followed by a burst
+const maxCatchUp = 100 * time.Millisecond
+
+// controller gates the update threads started by Start, to pause them and to keep to the rate of updates
+type controller struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	// updates that can still run while paused, from Advance
+	budget uint64
+	// updates a second, 0 runs them as fast as possible
+	rate float64
+	// when the next update can run to keep to the rate
+	next time.Time
+}
+
+func newController() *controller {
+	c := &controller{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// acquire waits until an update can run, it returns false once the threads are stopped
+// timer belongs to the calling thread, it is reused for every wait for the rate
+func (c *controller) acquire(quit chan struct{}, timer *time.Timer) bool {
+	c.mu.Lock()
+	for c.paused && c.budget == 0 && !closed(quit) {
+		c.cond.Wait()
+	}
+	if closed(quit) {
+		c.mu.Unlock()
+		return false
+	}
+	var wait time.Duration
+	if c.paused {
+		// stepping runs as fast as possible
+		c.budget--
+	} else if c.rate > 0 {
+		now := time.Now()
+		if c.next.Before(now.Add(-maxCatchUp)) {
+			c.next = now
+		}
+		wait = c.next.Sub(now)
+		c.next = c.next.Add(time.Duration(float64(time.Second) / c.rate))
+	}
+	c.mu.Unlock()
+
+	if wait > 0 {
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-quit:
+			timer.Stop()
+			return false
+		}
+	}
+	return true
+}
+
+func closed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Pause stops the update threads started by Start from updating until Resume
+func (w *World) Pause() {
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	w.ctl.paused = true
+	w.ctl.budget = 0
+}
+
+// Resume lets the update threads run again after Pause
+func (w *World) Resume() {
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	w.ctl.paused = false
+	w.ctl.next = time.Now()
+	w.ctl.cond.Broadcast()
+}
+
+// Paused tells if the world is paused
+func (w *World) Paused() bool {
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	return w.ctl.paused
+}
+
+// Advance lets a paused world run n more ticks with the update threads started by Start, then stay paused
+func (w *World) Advance(n int) {
+	if n <= 0 {
+		return
+	}
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	if !w.ctl.paused {
+		return
+	}
+	w.ctl.budget += uint64(n) * uint64(w.width*w.height)
+	w.ctl.cond.Broadcast()
+}
+
+// SetRate sets the cell updates a second the update threads started by Start aim for, 0 runs them as fast as possible
+func (w *World) SetRate(updatesPerSecond float64) {
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	w.ctl.rate = max(0, updatesPerSecond)
+	w.ctl.next = time.Now()
+}
+
+// Rate gives the cell updates a second the world aims for, 0 if it runs as fast as possible
+func (w *World) Rate() float64 {
+	w.ctl.mu.Lock()
+	defer w.ctl.mu.Unlock()
+	return w.ctl.rate
+}
@@ -0,0 +1,10 @@
+module example.com/sandlang
+
+go 1.23.3
+
+replace example.com/compile => ../compile
+
+require (
+	example.com/compile v0.0.0-00010101000000-000000000000
+	github.com/vjeantet/govaluate v1.3.0
+)
@@ -0,0 +1,91 @@
+package sandlang
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	"example.com/compile"
+)
+
+// Image draws the snapshot with each cell as a scale by scale square of its color, atoms that are not rendered are black
+func (s *Snapshot) Image(scale int) *image.RGBA {
+	scale = max(1, scale)
+	w, h := s.Size()
+	img := image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{A: 255}
+			if col, ok := s.Color(x, y); ok {
+				c = color.RGBA{R: col.R, G: col.G, B: col.B, A: 255}
+			}
+			for py := y * scale; py < (y+1)*scale; py++ {
+				for px := x * scale; px < (x+1)*scale; px++ {
+					img.SetRGBA(px, py, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// imageAtoms maps colors to atoms, the palette of the program first and then the colors of rendered atoms with a cdef color
+// Atoms without a color of their own are only found through the palette, apart from Empty which has black if nothing else does
+// as Image draws cells that are not rendered black
+func (w *World) imageAtoms() map[compile.Color]uint16 {
+	m := make(map[compile.Color]uint16)
+	ids := make([]uint16, 0, len(w.idMap))
+	for id := range w.idMap {
+		ids = append(ids, id)
+	}
+	// the first atom declared wins if several have the same color
+	slices.Sort(ids)
+	for _, id := range ids {
+		a := w.atoms[w.idMap[id]]
+		if _, ok := m[a.Color]; !ok && a.HasColor && a.ConstProp["render"] == 1 {
+			m[a.Color] = id
+		}
+	}
+	for col, name := range w.prog.Palette {
+		m[col] = w.revIdMap[name]
+	}
+	if _, ok := m[compile.Color{}]; !ok {
+		m[compile.Color{}] = w.revIdMap["Empty"]
+	}
+	return m
+}
+
+// SetImage changes each cell to the atom of the pixel at the same place, like SetCell. The image must be the size of the world
+// The atom of a pixel is the one the script's palette gives its color, or the atom with that color
+// Transparent pixels, and pixels of colors without an atom, leave their cell as it is. The colors without an atom are returned
+func (w *World) SetImage(img image.Image) ([]compile.Color, error) {
+	b := img.Bounds()
+	if b.Dx() != w.width || b.Dy() != w.height {
+		return nil, fmt.Errorf("image is %vx%v, the world is %vx%v", b.Dx(), b.Dy(), w.width, w.height)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	atoms := w.imageAtoms()
+	var unknown []compile.Color
+	seen := make(map[compile.Color]bool)
+	for y := range w.height {
+		for x := range w.width {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			col := compile.Color{R: c.R, G: c.G, B: c.B}
+			t, ok := atoms[col]
+			if !ok {
+				if !seen[col] {
+					seen[col] = true
+					unknown = append(unknown, col)
+				}
+				continue
+			}
+			w.changeType(w.rng, x, y, t)
+		}
+	}
+	return unknown, nil
+}
@@ -0,0 +1,63 @@
+package sandlang
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"example.com/compile"
+)
+
+func TestSetImageUncoloredAtoms(t *testing.T) {
+	w := testWorld(t, 2, 1)
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 255})
+	img.Set(1, 0, color.NRGBA{R: 0xFF, G: 0xC8, B: 0x57, A: 255})
+	unknown, err := w.SetImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) != 1 || unknown[0] != (compile.Color{R: 0x12, G: 0x34, B: 0x56}) {
+		t.Errorf("unknown colors %v, want #123456", unknown)
+	}
+	if c, _ := w.Cell(1, 0); c.Atom != "Sand" {
+		t.Errorf("colored pixel gave %v, want Sand", c.Atom)
+	}
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	w := testWorld(t, 3, 2)
+	w.SetCell(1, 0, "Sand")
+	w.SetCell(2, 1, "Sand")
+	var b bytes.Buffer
+	if err := png.Encode(&b, w.Snapshot().Image(1)); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := testWorld(t, 3, 2)
+	// every cell starts as Sand, so Empty cells must be set from the image
+	if err := loaded.Fill("Sand"); err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := loaded.SetImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) > 0 {
+		t.Errorf("unknown colors %v", unknown)
+	}
+	for y := range 2 {
+		for x := range 3 {
+			want, _ := w.Cell(x, y)
+			if got, _ := loaded.Cell(x, y); got.Atom != want.Atom {
+				t.Errorf("cell %v, %v is %v, want %v", x, y, got.Atom, want.Atom)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package sandlang
+
+// Inspection is everything known about one cell, to debug scripts
+// A property is in only one of Props, ConstProps and Defaults, the one rules read it from
+type Inspection struct {
+	Cell
+	Alias string
+	// constant properties of the atom, from its cdef lines
+	ConstProps map[string]float32
+	// default values of the script for the properties the cell has neither as a property nor as a cdef
+	Defaults map[string]float32
+	// id of the last rule that fired at the cell, -1 if none did since the world was made, loaded or reloaded
+	LastRule int
+}
+
+// Inspect gives the inspection of the cell at x, y, which must be inside the world
+func (s *Snapshot) Inspect(x, y int) Inspection {
+	c := s.Cell(x, y)
+	atom := s.sc.atoms[c.Atom]
+	in := Inspection{
+		Cell:       c,
+		Alias:      atom.Alias,
+		ConstProps: make(map[string]float32),
+		Defaults:   make(map[string]float32),
+		LastRule:   int(s.lastRule[y][x]),
+	}
+	// in the order evaluateMath reads them
+	for n, v := range atom.ConstProp {
+		if _, ok := c.Props[n]; !ok {
+			in.ConstProps[n] = v
+		}
+	}
+	for n, v := range s.sc.prog.Defaults {
+		_, prop := c.Props[n]
+		_, cdef := atom.ConstProp[n]
+		if !prop && !cdef {
+			in.Defaults[n] = v
+		}
+	}
+	return in
+}
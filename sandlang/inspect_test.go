@@ -0,0 +1,39 @@
+package sandlang
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"example.com/compile"
+)
+
+func TestInspectResolvesLikeRules(t *testing.T) {
+	src := `default heat 5
+default render 7
+default wet 2
+` + testScript
+	prog, errs, err := compile.CompileReader(strings.NewReader(src), "test.txt", false)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("compile: %v %v", err, errs)
+	}
+	w, err := NewWorld(prog, 1, 1, WithSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetCell(0, 0, "Sand"); err != nil {
+		t.Fatal(err)
+	}
+
+	in := w.Snapshot().Inspect(0, 0)
+	// heat is a property of Sand and render a cdef, so only wet comes from the defaults
+	if _, ok := in.Props["heat"]; !ok {
+		t.Errorf("props %v have no heat", in.Props)
+	}
+	if in.ConstProps["render"] != 1 {
+		t.Errorf("cdef render is %v, want 1", in.ConstProps["render"])
+	}
+	if want := map[string]float32{"wet": 2}; !maps.Equal(in.Defaults, want) {
+		t.Errorf("defaults %v, want %v", in.Defaults, want)
+	}
+}
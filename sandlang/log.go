@@ -0,0 +1,39 @@
+package sandlang
+
+import (
+	"math/rand"
+
+	"example.com/compile"
+)
+
+// LogEntry is the output of one log step
+type LogEntry struct {
+	Tick uint64 `json:"tick"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Atom string `json:"atom"`
+	// init, update or color
+	Section string `json:"section"`
+	// id of the rule the step is in, -1 outside of the update section
+	Rule   int        `json:"rule"`
+	Msg    string     `json:"msg"`
+	Values []LogValue `json:"values,omitempty"`
+}
+
+// LogValue is a value written by a log step, Expr is the maths statement as written in the script
+type LogValue struct {
+	Expr  string `json:"expr"`
+	Value any    `json:"value"`
+}
+
+// log runs a log step of atom for the cell at x, y, reading properties from g with the atoms of sc
+func (w *World) log(sc *script, rng *rand.Rand, g [][]cell, step compile.Step, atom, section string, rule int, s, x, y int) {
+	if w.logf == nil {
+		return
+	}
+	e := LogEntry{Tick: w.Tick(), X: x, Y: y, Atom: atom, Section: section, Rule: rule, Msg: step.Msg}
+	for _, arg := range step.Args {
+		e.Values = append(e.Values, LogValue{Expr: arg.Src, Value: sc.evaluateMath(rng, g, arg.Eval, arg.Vars, arg.RandVars, s, x, y)})
+	}
+	w.logf(e)
+}
@@ -0,0 +1,58 @@
+package sandlang
+
+import (
+	"fmt"
+
+	"example.com/compile"
+)
+
+// Reload switches the world to prog, usually a new version of its script, keeping the cells
+// Cells are matched to the atoms of prog by name, and those of atoms prog does not have become Empty
+// Properties the new version of an atom adds get their default value, and those it no longer has are dropped
+func (w *World) Reload(prog *compile.Program) (LoadReport, error) {
+	empty, ok := prog.Atoms["Empty"]
+	if !ok {
+		return LoadReport{}, fmt.Errorf("program has no Empty atom")
+	}
+	sc := newScript(prog)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	report := LoadReport{ScriptChanged: prog.Hash != w.prog.Hash}
+	dropped := make(map[string]bool)
+	for yi := range w.grid {
+		for xi := range w.grid[yi] {
+			c := &w.grid[yi][xi]
+			name := w.idMap[c.t]
+			a, ok := sc.atoms[name]
+			if !ok {
+				if !dropped[name] {
+					dropped[name] = true
+					report.Dropped = append(report.Dropped, name)
+				}
+				c.t = empty.Id
+				c.prop = make(map[string]float32)
+				for n, v := range empty.Prop {
+					c.prop[n] = v
+				}
+				continue
+			}
+
+			c.t = a.Id
+			for n := range w.atoms[name].Prop {
+				if _, ok := a.Prop[n]; !ok {
+					delete(c.prop, n)
+				}
+			}
+			for n, v := range a.Prop {
+				if _, ok := c.prop[n]; !ok {
+					c.prop[n] = v
+				}
+			}
+		}
+	}
+	w.script = sc
+	w.clearLastRules()
+	w.zoneRadius.Store(zoneRadius(prog))
+	return report, nil
+}
@@ -0,0 +1,84 @@
+package sandlang
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"example.com/compile"
+)
+
+func TestReloadKeepsProps(t *testing.T) {
+	first := testScript + `
+atom Old alias O {
+    section property {
+        cdef render 1
+    }
+}
+`
+	// Rock comes first so that the ids of the atoms change, and Sand gains a property
+	second := `
+atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+
+atom Rock alias R {
+    section property {
+        cdef render 1
+    }
+}
+
+atom Sand alias S {
+    section property {
+        cdef render 1
+        def heat 0
+        def wet 1
+    }
+}
+`
+	compileSrc := func(src string) *compile.Program {
+		prog, errs, err := compile.CompileReader(strings.NewReader(src), "test.txt", false)
+		if err != nil || len(errs) > 0 {
+			t.Fatalf("compile: %v %v", err, errs)
+		}
+		return prog
+	}
+	w, err := NewWorld(compileSrc(first), 3, 1, WithSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, atom := range []string{"Sand", "Old", "Sand"} {
+		if err := w.SetCell(x, 0, atom); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.SetProp(0, 0, "heat", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	// reloaded while the update threads run, as the window does
+	w.Start()
+	report, err := w.Reload(compileSrc(second))
+	w.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Dropped, []string{"Old"}) || !report.ScriptChanged {
+		t.Errorf("report %+v, want Old dropped and the script changed", report)
+	}
+
+	want := []Cell{
+		{Atom: "Sand", Props: map[string]float32{"heat": 42, "wet": 1}},
+		{Atom: "Empty", Props: map[string]float32{}},
+		{Atom: "Sand", Props: map[string]float32{"heat": 0, "wet": 1}},
+	}
+	for x, c := range want {
+		got, _ := w.Cell(x, 0)
+		if got.Atom != c.Atom || !maps.Equal(got.Props, c.Props) {
+			t.Errorf("cell %v is %+v, want %+v", x, got, c)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package sandlang
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"example.com/compile"
+)
+
+// SnapshotVersion is the version of the format written by Save
+const SnapshotVersion = 1
+
+// savedWorld is a saved snapshot, written as gzipped JSON
+type savedWorld struct {
+	Version int `json:"version"`
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+	// Hash of the script the world was running
+	Script string `json:"script"`
+	Tick   uint64 `json:"tick"`
+	// names of the atoms, cells refer to them by index so they can be found again if the script changed
+	Atoms []string `json:"atoms"`
+	// cells row by row
+	Cells []savedCell `json:"cells"`
+}
+
+type savedCell struct {
+	Atom  int                  `json:"a"`
+	Props map[string]savedProp `json:"p,omitempty"`
+}
+
+// savedProp is a property value, written as a number or as "NaN", "+Inf" or "-Inf" which JSON has no numbers for
+type savedProp float32
+
+func (p savedProp) MarshalJSON() ([]byte, error) {
+	f := float64(p)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(float32(p))
+}
+
+func (p *savedProp) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		switch s {
+		case "NaN":
+			*p = savedProp(math.NaN())
+		case "+Inf":
+			*p = savedProp(math.Inf(1))
+		case "-Inf":
+			*p = savedProp(math.Inf(-1))
+		default:
+			return fmt.Errorf("property value %q is not a number", s)
+		}
+		return nil
+	}
+	var f float32
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*p = savedProp(f)
+	return nil
+}
+
+// LoadReport tells what changed when a snapshot was loaded, or the script reloaded
+type LoadReport struct {
+	// the snapshot was saved by a different script, so atoms were found by name
+	ScriptChanged bool
+	// atoms of the snapshot the script does not have, their cells became Empty
+	Dropped []string
+}
+
+// Save writes the snapshot in a versioned format that Load and LoadWorld read
+func (s *Snapshot) Save(wr io.Writer) error {
+	w, h := s.Size()
+	ids := make([]uint16, 0, len(s.sc.idMap))
+	for id := range s.sc.idMap {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	index := make(map[uint16]int)
+	sw := savedWorld{Version: SnapshotVersion, Width: w, Height: h, Script: s.sc.prog.Hash, Tick: s.tick, Cells: make([]savedCell, 0, w*h)}
+	for i, id := range ids {
+		index[id] = i
+		sw.Atoms = append(sw.Atoms, s.sc.idMap[id])
+	}
+	for yi := range s.grid {
+		for _, c := range s.grid[yi] {
+			sc := savedCell{Atom: index[c.t]}
+			if len(c.prop) > 0 {
+				sc.Props = make(map[string]savedProp, len(c.prop))
+				for n, v := range c.prop {
+					sc.Props[n] = savedProp(v)
+				}
+			}
+			sw.Cells = append(sw.Cells, sc)
+		}
+	}
+
+	zw := gzip.NewWriter(wr)
+	if err := json.NewEncoder(zw).Encode(sw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Save writes the world as Snapshot.Save does
+func (w *World) Save(wr io.Writer) error {
+	return w.Snapshot().Save(wr)
+}
+
+func readSnapshot(r io.Reader) (*savedWorld, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot: %v", err)
+	}
+	defer zr.Close()
+	var sw savedWorld
+	if err := json.NewDecoder(zr).Decode(&sw); err != nil {
+		return nil, fmt.Errorf("not a snapshot: %v", err)
+	}
+
+	if sw.Version != SnapshotVersion {
+		return nil, fmt.Errorf("snapshot version %v is not supported, expected %v", sw.Version, SnapshotVersion)
+	}
+	if sw.Width <= 0 || sw.Height <= 0 || len(sw.Cells) != sw.Width*sw.Height {
+		return nil, fmt.Errorf("snapshot has %v cells for a %vx%v world", len(sw.Cells), sw.Width, sw.Height)
+	}
+	for _, c := range sw.Cells {
+		if c.Atom < 0 || c.Atom >= len(sw.Atoms) {
+			return nil, fmt.Errorf("snapshot cell has atom %v of %v", c.Atom, len(sw.Atoms))
+		}
+	}
+	return &sw, nil
+}
+
+// load replaces the grid with the cells of a snapshot of the same size
+// Cells start with the properties of their atom in the current script, and then take the saved ones
+func (w *World) load(sw *savedWorld) LoadReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	report := LoadReport{ScriptChanged: sw.Script != w.prog.Hash}
+	ids := make([]uint16, len(sw.Atoms))
+	empty := w.revIdMap["Empty"]
+	for i, name := range sw.Atoms {
+		if id, ok := w.revIdMap[name]; ok {
+			ids[i] = id
+		} else {
+			ids[i] = empty
+			report.Dropped = append(report.Dropped, name)
+		}
+	}
+
+	g := make([][]cell, sw.Height)
+	for yi := range g {
+		g[yi] = make([]cell, sw.Width)
+		for xi := range g[yi] {
+			sc := sw.Cells[yi*sw.Width+xi]
+			c := cell{t: ids[sc.Atom], prop: make(map[string]float32)}
+			for n, v := range w.atoms[w.idMap[c.t]].Prop {
+				c.prop[n] = v
+			}
+			if w.idMap[c.t] == sw.Atoms[sc.Atom] {
+				for n, v := range sc.Props {
+					c.prop[n] = float32(v)
+				}
+			}
+			g[yi][xi] = c
+		}
+	}
+
+	w.grid = g
+	w.clearLastRules()
+	w.updates.Store(sw.Tick * uint64(w.width*w.height))
+	return report
+}
+
+// Load replaces the world with a snapshot written by Save, which must be the same size
+// Atoms are found by name, so snapshots of an older version of the script can be loaded
+func (w *World) Load(r io.Reader) (LoadReport, error) {
+	sw, err := readSnapshot(r)
+	if err != nil {
+		return LoadReport{}, err
+	}
+	if sw.Width != w.width || sw.Height != w.height {
+		return LoadReport{}, fmt.Errorf("snapshot is %vx%v, the world is %vx%v", sw.Width, sw.Height, w.width, w.height)
+	}
+	return w.load(sw), nil
+}
+
+// LoadWorld makes a world running prog from a snapshot written by Save, with the size of the snapshot
+func LoadWorld(prog *compile.Program, r io.Reader, opts ...Option) (*World, LoadReport, error) {
+	sw, err := readSnapshot(r)
+	if err != nil {
+		return nil, LoadReport{}, err
+	}
+	w, err := newWorld(prog, sw.Width, sw.Height, opts)
+	if err != nil {
+		return nil, LoadReport{}, err
+	}
+	return w, w.load(sw), nil
+}
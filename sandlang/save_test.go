@@ -0,0 +1,68 @@
+package sandlang
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"example.com/compile"
+)
+
+const testScript = `
+atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+
+atom Sand alias S {
+    section property {
+        cdef render 1
+        cdef color #FFC857
+
+        def heat 0
+    }
+}
+`
+
+func testWorld(t *testing.T, w, h int) *World {
+	t.Helper()
+	prog, errs, err := compile.CompileReader(strings.NewReader(testScript), "test.txt", false)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("compile: %v %v", err, errs)
+	}
+	world, err := NewWorld(prog, w, h, WithSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return world
+}
+
+func TestSaveNonFiniteProps(t *testing.T) {
+	w := testWorld(t, 3, 1)
+	for x, v := range []float32{float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1))} {
+		if err := w.SetCell(x, 0, "Sand"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetProp(x, 0, "heat", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := w.Save(&b); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded := testWorld(t, 3, 1)
+	if _, err := loaded.Load(&b); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	for x, check := range []func(float64) bool{math.IsNaN, func(f float64) bool { return math.IsInf(f, 1) }, func(f float64) bool { return math.IsInf(f, -1) }} {
+		c, _ := loaded.Cell(x, 0)
+		if v := c.Props["heat"]; c.Atom != "Sand" || !check(float64(v)) {
+			t.Errorf("cell %v: got %v with heat %v", x, c.Atom, v)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sandlang
+
+import (
+	"math/rand"
+	"slices"
+
+	"example.com/compile"
+)
+
+// Snapshot is a copy of a world at one moment, which can be read while the world keeps running
+// A snapshot must only be used by one goroutine at a time
+type Snapshot struct {
+	w *World
+	// the script when the snapshot was taken
+	sc   *script
+	tick uint64
+	grid [][]cell
+	// id of the last rule that fired at each cell
+	lastRule [][]int32
+	// random numbers of color sections, which do not change the world
+	rng *rand.Rand
+}
+
+// Snapshot copies the world between two updates
+func (w *World) Snapshot() *Snapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	g := make([][]cell, w.height)
+	lastRule := make([][]int32, w.height)
+	for yi := range w.grid {
+		lastRule[yi] = slices.Clone(w.lastRule[yi])
+		g[yi] = make([]cell, w.width)
+		for xi, c := range w.grid[yi] {
+			prop := make(map[string]float32, len(c.prop))
+			for k, v := range c.prop {
+				prop[k] = v
+			}
+			g[yi][xi] = cell{t: c.t, prop: prop}
+		}
+	}
+	return &Snapshot{w: w, sc: w.script, tick: w.Tick(), grid: g, lastRule: lastRule, rng: rand.New(rand.NewSource(w.seed ^ int64(w.updates.Load())))}
+}
+
+// Size gives the width and height of the world
+func (s *Snapshot) Size() (int, int) {
+	return s.w.width, s.w.height
+}
+
+// Tick gives the tick the snapshot was taken at
+func (s *Snapshot) Tick() uint64 {
+	return s.tick
+}
+
+// Atom gives the name of the atom at x, y, which must be inside the world
+func (s *Snapshot) Atom(x, y int) string {
+	return s.sc.idMap[s.grid[y][x].t]
+}
+
+// Cell gives the cell at x, y, which must be inside the world
+func (s *Snapshot) Cell(x, y int) Cell {
+	return s.grid[y][x].export(s.sc.idMap)
+}
+
+// Color gives the color of the cell at x, y, running its color section if it has one
+// ok is false if the atom is not rendered
+func (s *Snapshot) Color(x, y int) (col compile.Color, ok bool) {
+	id := s.Atom(x, y)
+	atom := s.sc.atoms[id]
+	if atom.ConstProp["render"] != 1 {
+		return compile.Color{}, false
+	}
+	if !atom.DynamicColor {
+		return atom.Color, true
+	}
+	return s.computeColor(id, atom.ColorRules, x, y), true
+}
+
+func (s *Snapshot) computeColor(atom string, rules []compile.ColorRule, x, y int) compile.Color {
+	rng, g := s.rng, s.grid
+	// fmt.Println("START COMPUTE COLOR")
+	// fmt.Printf("c %+v\n", g[y][x])
+	for _, r := range rules {
+		if r.Log != nil {
+			s.w.log(s.sc, rng, g, *r.Log, atom, "color", -1, 0, x, y)
+			continue
+		}
+		conRes := s.sc.evaluateMath(rng, g, r.Cond.Expr, r.Cond.Names, r.Cond.RandVars, 0, x, y)
+		if conRes == true {
+			// fmt.Println(r.Col.R)
+			rval := uint8(s.sc.evaluateMath(rng, g, r.Col.R.Eval, r.Col.R.Vars, r.Col.R.RandVars, 0, x, y).(float64))
+			gval := uint8(s.sc.evaluateMath(rng, g, r.Col.G.Eval, r.Col.G.Vars, r.Col.G.RandVars, 0, x, y).(float64))
+			bval := uint8(s.sc.evaluateMath(rng, g, r.Col.B.Eval, r.Col.B.Vars, r.Col.B.RandVars, 0, x, y).(float64))
+			// fmt.Println(rval)
+			return compile.Color{R: rval, G: gval, B: bval}
+		}
+	}
+	return compile.Color{R: uint8(0), G: uint8(0), B: uint8(0)}
+}
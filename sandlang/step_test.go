@@ -0,0 +1,142 @@
+package sandlang
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"example.com/compile"
+)
+
+// stepScript has sand falling and sliding, and heating up at random
+const stepScript = `
+atom Empty alias E {
+    section property {
+        cdef render 0
+    }
+}
+
+atom Sand alias S {
+    section property {
+        cdef render 1
+        def heat 0
+    }
+    section update {
+        self -> P-0.3 {
+            inc [heat] by 1
+        }
+        match (0, 0, 1, 2) {
+            pattern
+            x
+            E
+        }
+        -> {
+            pattern
+            E
+            x
+        }
+        match (0, 0, 2, 2) sym(x) {
+            pattern
+            x _
+            _ E
+        }
+        -> {
+            pattern
+            E _
+            _ x
+        }
+    }
+}
+
+world {
+    fill rect(0, 0, 16, 6) Sand
+    place (8, 10) Sand
+}
+`
+
+func stepWorld(t *testing.T, opts ...Option) *World {
+	t.Helper()
+	prog, errs, err := compile.CompileReader(strings.NewReader(stepScript), "step.txt", false)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("compile: %v %v", err, errs)
+	}
+	w, err := NewWorld(prog, 16, 16, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// cells gives every cell of w, row by row
+func cells(w *World) []Cell {
+	snap := w.Snapshot()
+	sw, sh := snap.Size()
+	var out []Cell
+	for y := range sh {
+		for x := range sw {
+			out = append(out, snap.Cell(x, y))
+		}
+	}
+	return out
+}
+
+func sameCells(a, b []Cell) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Atom != b[i].Atom || !maps.Equal(a[i].Props, b[i].Props) {
+			return false
+		}
+	}
+	return true
+}
+
+func atomCounts(cs []Cell) map[string]int {
+	n := make(map[string]int)
+	for _, c := range cs {
+		n[c.Atom]++
+	}
+	return n
+}
+
+func TestStepSameSeed(t *testing.T) {
+	a := stepWorld(t, WithSeed(7), WithWorkers(1))
+	b := stepWorld(t, WithSeed(7), WithWorkers(1))
+	a.Step(20)
+	// the ticks can be split, the targets of the threads are kept between steps
+	b.Step(5)
+	b.Step(15)
+	if !sameCells(cells(a), cells(b)) {
+		t.Error("one update thread and the same seed gave different worlds")
+	}
+
+	if sameCells(cells(a), cells(stepWorld(t))) {
+		t.Fatal("nothing changed in 20 ticks")
+	}
+	c := stepWorld(t, WithSeed(8), WithWorkers(1))
+	c.Step(20)
+	if sameCells(cells(a), cells(c)) {
+		t.Error("another seed gave the same world")
+	}
+}
+
+// TestStepWorkers checks what a seed keeps with several update threads: they interleave differently on every
+// run, so only the number of updates and what the rules conserve are the same
+func TestStepWorkers(t *testing.T) {
+	start := atomCounts(cells(stepWorld(t)))
+	for _, n := range []int{1, 4} {
+		a := stepWorld(t, WithSeed(7), WithWorkers(n))
+		b := stepWorld(t, WithSeed(7), WithWorkers(n))
+		a.Step(20)
+		b.Step(20)
+		if a.Updates() != b.Updates() || a.Updates() != 20*16*16 {
+			t.Errorf("%v threads: %v and %v updates, want %v", n, a.Updates(), b.Updates(), 20*16*16)
+		}
+		for _, w := range []*World{a, b} {
+			if got := atomCounts(cells(w)); !maps.Equal(got, start) {
+				t.Errorf("%v threads: atoms %v, want %v", n, got, start)
+			}
+		}
+	}
+}
@@ -0,0 +1,697 @@
+package sandlang
+
+import (
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"time"
+
+	"example.com/compile"
+	"github.com/vjeantet/govaluate"
+)
+
+func (w *World) inGrid(x, y int) bool {
+	return x >= 0 && y >= 0 && x < w.width && y < w.height
+}
+
+func (w *World) inCellSet(t uint16, def map[string][]string, rule string) bool {
+	if rule[0] == '~' {
+		if v, ok := def[rule[1:]]; ok {
+			// fmt.Println(v, "^"+w.atoms[w.idMap[grid[tarY][tarX].t]].Alias, w.idMap[grid[tarY][tarX].t])
+			if slices.Contains(v, w.idMap[t]) || slices.Contains(v, "^"+w.atoms[w.idMap[t]].Alias) {
+				return false
+			}
+		}
+	} else {
+		if v, ok := def[rule]; ok {
+			if !(slices.Contains(v, w.idMap[t]) || slices.Contains(v, "^"+w.atoms[w.idMap[t]].Alias)) {
+				return false
+			}
+		} else if a, ok := w.aliasMap[rule]; ok {
+			if w.idMap[t] != a {
+				return false
+			}
+		} else {
+			return false
+		}
+	}
+
+	return true
+}
+
+// work updates cells until quit is closed, or until limit cells were updated if it is not 0
+// The threads started by Start are gated by the controller, Step runs as fast as possible
+func (w *World) work(i int, quit chan struct{}, limit uint64, gated bool) {
+	rng := w.rngs[i]
+	// the target is kept between runs so that the same seed gives the same world however the ticks are split
+	target := &w.targets[i]
+	// stopped until acquire waits on it
+	timer := time.NewTimer(0)
+	timer.Stop()
+	defer timer.Stop()
+outside:
+	for {
+		select {
+		case <-quit:
+			break outside
+		default:
+			if gated && !w.ctl.acquire(quit, timer) {
+				break outside
+			}
+			// s := time.Now()
+			var rx, ry int
+			// if testUpdateX == -1 {
+			// rx, ry = rng.Intn(w.width), rng.Intn(w.height)
+			rx, ry = target[0], target[1]
+			// 	continue outside
+			// } else {
+			// rx, ry = testUpdateX, testUpdateY
+			// testUpdateX, testUpdateY = -1, -1
+			// }
+			// rx, ry := 4, 4
+			zx, zy := int(rx/zoneSize), int(ry/zoneSize)
+			if n := w.updates.Add(1); limit > 0 && n > limit {
+				// the update did not happen, so Step ends on exactly limit
+				w.updates.Add(^uint64(0))
+				break outside
+			}
+
+			// taken before the zones, which keeps Reload from changing the radius until they are unlocked
+			w.mu.RLock()
+			radius := int(w.zoneRadius.Load())
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if zx+dx >= 0 && zx+dx < len(w.zones[0]) && zy+dy >= 0 && zy+dy < len(w.zones) {
+						w.zones[zy+dy][zx+dx].Lock()
+						// fmt.Println("zlock", zx+dx, zy+dy, rx, ry)
+					}
+				}
+			}
+
+			randomizeTarget := true
+
+			if name, ok := w.idMap[w.grid[ry][rx].t]; ok {
+				ref := *w.atoms[name]
+
+				for _, v := range rng.Perm(len(ref.AlwaysRules)) {
+					ind := v
+					rule := ref.AlwaysRules[ind]
+					// fmt.Println(rule)
+
+					if rng.Float64() > rule.Prob {
+						continue
+					}
+					ruleApply := true
+
+					var ox, oy int
+					s := 0
+					if rule.Self {
+						// self rules have no cells to match and no symmetry
+						ox, oy = rx, ry
+					} else {
+						if !(rule.XSym && rng.Intn(2) == 0) {
+							ox = rx - int(rule.Ox)
+						} else {
+							// ox = rx + int(rule.Ox) - int(rule.W)
+							ox = rx - (int(rule.W) - int(rule.Ox) - 1)
+							s |= symX
+						}
+
+						if !(rule.YSym && rng.Intn(2) == 0) {
+							oy = ry - int(rule.Oy)
+						} else {
+							// fmt.Println("YSym")
+							oy = ry - (int(rule.H) - int(rule.Oy) - 1)
+							s |= symY
+						}
+
+						// fmt.Println(rule.XSym, rule.YSym, rng.Intn(2))
+
+						// fmt.Printf("ox: %v, oy: %v, s: %v\n", ox, oy, s)
+
+						// sx, sy := rule.XSym && rng.Intn(2) == 0, rule.YSym && rng.Intn(2) == 0
+						if !rule.NoMatchPattern {
+							if !w.matchRule(ref, ox, oy, ind, s, true) {
+								ruleApply = false
+								continue
+							}
+						}
+					}
+
+					// fmt.Println(ref.ConstProp)
+
+					for _, con := range rule.MatchCon {
+						res := w.evaluateMath(rng, w.grid, con.Expr, con.Names, con.RandVars, s, rx, ry)
+
+						// fmt.Println(res)
+
+						if res == false {
+							ruleApply = false
+							break
+						}
+					}
+
+					if !ruleApply {
+						// if !rule.DontBreak {
+						// 	break
+						// } else {
+						// 	continue
+						// }
+						continue
+					}
+
+					if ruleApply {
+						// fmt.Println("APPLYING")
+						w.doSteps(rng, name, rule, ox, oy, s, rx, ry)
+						// if _, ok := w.grid[ry-1][rx].prop["lifetime"]; ok {
+						// w.grid[ry-1][rx].prop["lifetime"] = w.grid[ry][rx].prop["lifetime"] + 1
+						// }
+					}
+
+					if rule.Shift[0] != 0 || rule.Shift[1] != 0 {
+						randomizeTarget = false
+						target[0] = rx + rule.Shift[0]*((s&symX)*2-1)
+						target[1] = ry + rule.Shift[1]*(((s&symX)>>1)*2-1)
+					}
+				}
+
+				totalLength := len(ref.Rules) + len(ref.ExtRules)
+				// fmt.Println(totalLength)
+				if totalLength > 0 {
+					if rng.Intn(totalLength) < len(ref.Rules) {
+						for _, v := range rng.Perm(len(ref.Rules)) {
+							// ind := rng.Intn(len(ref.Rules))
+							ind := v
+							// fmt.Println(ind)
+							rule := ref.Rules[ind]
+							// for ind, rule := range ref.Rules {
+							if rng.Float64() > rule.Prob {
+								// if !rule.DontBreak {
+								// 	break
+								// } else {
+								// 	continue
+								// }
+								continue
+							}
+							ruleApply := true
+							// s := 0
+							// if rule.XSym && rng.Intn(2) == 0 {
+							// 	s |= symX
+							// }
+							// if rule.YSym && rng.Intn(2) == 0 {
+							// 	s |= symY
+							// }
+
+							var ox, oy int
+							s := 0
+							if rule.Self {
+								// self rules have no cells to match and no symmetry
+								ox, oy = rx, ry
+							} else {
+								if !(rule.XSym && rng.Intn(2) == 0) {
+									ox = rx - int(rule.Ox)
+								} else {
+									// ox = rx + int(rule.Ox) - int(rule.W)
+									ox = rx - (int(rule.W) - int(rule.Ox) - 1)
+									s |= symX
+								}
+
+								if !(rule.YSym && rng.Intn(2) == 0) {
+									oy = ry - int(rule.Oy)
+								} else {
+									// fmt.Println("YSym")
+									oy = ry - (int(rule.H) - int(rule.Oy) - 1)
+									s |= symY
+								}
+
+								// fmt.Println(rule.XSym, rule.YSym, rng.Intn(2))
+
+								// fmt.Printf("ox: %v, oy: %v, s: %v\n", ox, oy, s)
+
+								// sx, sy := rule.XSym && rng.Intn(2) == 0, rule.YSym && rng.Intn(2) == 0
+								if !rule.NoMatchPattern {
+									if !w.matchRule(ref, ox, oy, ind, s, false) {
+										ruleApply = false
+										continue
+									}
+								}
+							}
+
+							// fmt.Println(ref.ConstProp)
+
+							for _, con := range rule.MatchCon {
+								res := w.evaluateMath(rng, w.grid, con.Expr, con.Names, con.RandVars, s, rx, ry)
+
+								// fmt.Println(res)
+
+								if res == false {
+									ruleApply = false
+									break
+								}
+							}
+
+							if !ruleApply {
+								// if !rule.DontBreak {
+								// 	break
+								// } else {
+								// 	continue
+								// }
+								continue
+							}
+
+							if ruleApply {
+								// fmt.Println("APPLYING")
+								w.doSteps(rng, name, rule, ox, oy, s, rx, ry)
+								// if _, ok := w.grid[ry-1][rx].prop["lifetime"]; ok {
+								// w.grid[ry-1][rx].prop["lifetime"] = w.grid[ry][rx].prop["lifetime"] + 1
+								// }
+							}
+
+							if rule.Shift[0] != 0 || rule.Shift[1] != 0 {
+								randomizeTarget = false
+								target[0] = rx + rule.Shift[0]*((s&symX)*2-1)
+								target[1] = ry + rule.Shift[1]*(((s&symX)>>1)*2-1)
+							}
+
+							if !rule.DontBreak {
+								break
+							}
+						}
+					} else {
+						ind := rng.Intn(len(ref.ExtRules))
+						rule := ref.ExtRules[ind]
+						// fmt.Println(rule)
+						param := rule.Param
+
+						running := true
+
+						if v, ok := param["prob"]; ok {
+							p, err := strconv.ParseFloat(v, 64)
+							if err != nil {
+								panic(err)
+							}
+
+							if rng.Float64() > p {
+								running = false
+							}
+						}
+
+						if running {
+							switch rule.Name {
+							case "randomMove":
+								dx, dy := 0, 0
+								for dx == 0 && dy == 0 {
+									dx, dy = rng.Intn(3)-1, rng.Intn(3)-1
+								}
+								// fmt.Println(dx, dy)
+								xp, yp := rx+dx, ry+dy
+
+								if w.inGrid(xp, yp) {
+									replSym := param["repl"]
+									// if _, ok := ref.Def[replSym]; ok {
+									toCell := w.grid[yp][xp]
+									// fmt.Println(toCell, w.idMap[w.grid[ry][rx].t], ref.Def, replSym)
+									if w.inCellSet(w.grid[yp][xp].t, ref.Def, replSym) {
+										// fmt.Println("MARKER")
+										w.transfer(w.grid[ry][rx], xp, yp)
+										w.transfer(toCell, rx, ry)
+									}
+									// }
+								}
+							case "sandLike":
+								dx, dy := rng.Intn(3)-1, 1
+								if dx == 0 {
+									// fmt.Println(dx, dy)
+									xp, yp := rx+dx, ry+dy
+
+									if w.inGrid(xp, yp) {
+										replSym := param["repl"]
+										// if _, ok := ref.Def[replSym]; ok {
+										toCell := w.grid[yp][xp]
+										// fmt.Println(toCell, w.idMap[w.grid[ry][rx].t], ref.Def, replSym)
+										if w.inCellSet(w.grid[yp][xp].t, ref.Def, replSym) {
+											// fmt.Println("MARKER")
+											w.transfer(w.grid[ry][rx], xp, yp)
+											w.transfer(toCell, rx, ry)
+										}
+										// }
+									}
+								} else {
+									xp, yp := rx+dx, ry+dy
+									replSym := param["repl"]
+									if w.inGrid(rx, ry+1) && w.grid[ry+1][rx].t != w.revIdMap["Empty"] && w.inGrid(xp, yp) {
+										// if _, ok := ref.Def[replSym]; ok {
+										toCell := w.grid[yp][xp]
+										// fmt.Println(toCell, w.idMap[w.grid[ry][rx].t], ref.Def, replSym)
+										if w.inCellSet(w.grid[yp][xp].t, ref.Def, replSym) {
+											// fmt.Println("MARKER")
+											w.transfer(w.grid[ry][rx], xp, yp)
+											w.transfer(toCell, rx, ry)
+										}
+										// }
+									}
+								}
+							case "fall":
+								// fmt.Println(dx, dy)
+								xp, yp := rx, ry+1
+
+								if w.inGrid(xp, yp) {
+									replSym := param["repl"]
+									// if _, ok := ref.Def[replSym]; ok {
+									toCell := w.grid[yp][xp]
+									// fmt.Println(toCell, w.idMap[w.grid[ry][rx].t], ref.Def, replSym)
+									if w.inCellSet(w.grid[yp][xp].t, ref.Def, replSym) {
+										// fmt.Println("MARKER")
+										w.transfer(w.grid[ry][rx], xp, yp)
+										w.transfer(toCell, rx, ry)
+									}
+									// }
+								}
+							}
+						}
+					}
+				}
+			}
+
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if zx+dx >= 0 && zx+dx < len(w.zones[0]) && zy+dy >= 0 && zy+dy < len(w.zones) {
+						w.zones[zy+dy][zx+dx].Unlock()
+						// fmt.Println("zunlock", zx+dx, zy+dy)
+					}
+				}
+			}
+			w.mu.RUnlock()
+
+			if randomizeTarget {
+				*target = [2]int{rng.Intn(w.width), rng.Intn(w.height)}
+			}
+			// fmt.Println(time.Since(s))
+			// break outside
+		}
+	}
+}
+
+func (w *World) changeType(rng *rand.Rand, x, y int, newT uint16) {
+	name := w.idMap[newT]
+	w.grid[y][x].t = newT
+	w.grid[y][x].prop = make(map[string]float32)
+	for n, val := range w.atoms[name].Prop {
+		// if _, ok := w.grid[y][x].prop[n]; !ok {
+		w.grid[y][x].prop[n] = val
+		// }
+	}
+
+	// fmt.Println(w.grid[y][x].prop)
+
+	w.doInit(rng, x, y, newT)
+}
+
+func (w *World) doInit(rng *rand.Rand, x, y int, t uint16) {
+	steps := w.atoms[w.idMap[t]].Init
+	for _, step := range steps {
+		switch step.Opcode {
+		case 7:
+			w.log(w.script, rng, w.grid, step, w.idMap[t], "init", -1, 0, x, y)
+		case 5:
+			name := step.Name[0]
+			res := w.evaluateMath(rng, w.grid, step.Eval, step.Vars, step.RandVars, 0, x, y)
+
+			w.grid[y][x].prop[name] = float32(res.(float64))
+		}
+	}
+}
+
+func (w *World) matchRule(atom compile.AtomRef, ox, oy int, ruleIndex int, s int, alwaysRule bool) bool {
+	// fmt.Println(ruleIndex)
+	var r compile.Rule
+	if alwaysRule {
+		r = atom.AlwaysRules[ruleIndex]
+	} else {
+		r = atom.Rules[ruleIndex]
+	}
+
+	// fmt.Println(s&symX, s&symY)
+	// ox, oy := rx-int(r.Ox), ry-int(r.Oy)
+	matching := true
+
+out:
+	for dy := 0; dy < int(r.H); dy++ {
+		var ruleY int
+		if s&symY == symY {
+			ruleY = int(r.H) - dy - 1
+		} else {
+			ruleY = dy
+		}
+		for dx := 0; dx < int(r.W); dx++ {
+			tarX, tarY := ox+dx, oy+dy
+			var ruleX int
+			if s&symX == 1 {
+				ruleX = int(r.W) - dx - 1
+			} else {
+				ruleX = dx
+			}
+			cellRule := r.Match[ruleY*int(r.W)+ruleX]
+			// fmt.Println(cellRule)
+			outside := false
+			if tarX < 0 || tarX >= w.width || tarY < 0 || tarY >= w.height {
+				outside = true
+			}
+			if outside {
+				if cellRule != "e" {
+					matching = false
+				}
+				break out
+			}
+			switch cellRule {
+			case "e":
+				if !outside {
+					matching = false
+					break out
+				}
+			case "*":
+				if outside {
+					matching = false
+					break out
+				}
+			case "x":
+				continue
+			case "_":
+				if !outside {
+					if w.idMap[w.grid[tarY][tarX].t] != "Empty" {
+						matching = false
+						break out
+					}
+				} else {
+					matching = false
+					break out
+				}
+			case "n":
+				if !outside {
+					if w.idMap[w.grid[tarY][tarX].t] == "Empty" {
+						matching = false
+						break out
+					}
+				} else {
+					matching = false
+					break out
+				}
+			default:
+				inSet := w.inCellSet(w.grid[tarY][tarX].t, atom.Def, cellRule)
+
+				if !inSet {
+					matching = false
+					break out
+				}
+			}
+		}
+	}
+	return matching
+}
+
+func (w *World) doSteps(rng *rand.Rand, atom string, rule compile.Rule, ox, oy int, s int, rx, ry int) {
+	// fmt.Println("o", ox, oy)
+	// fmt.Println("DO STEP")
+	w.lastRule[ry][rx] = int32(rule.Id)
+	w.applied.Add(1)
+	steps := rule.Steps
+	localSymbols := make(map[string]cell)
+
+	for _, step := range steps {
+		var cx, cy int
+		if step.Opcode == 5 || step.Opcode == 1 {
+			if s&symX == 0 {
+				cx = int(step.Operand[0])
+			} else {
+				cx = int(rule.W) - int(step.Operand[0]) - 1
+			}
+
+			if s&symY == 0 {
+				cy = int(step.Operand[1])
+			} else {
+				cy = int(rule.H) - int(step.Operand[1]) - 1
+			}
+		}
+		switch step.Opcode {
+		case 5:
+			sym := step.Name[0]
+			localSymbols[sym] = w.grid[oy+cy][ox+cx]
+			// fmt.Printf("c %v, %v localSymbols %+v\n", cx, cy, localSymbols)
+		case 4:
+			// fmt.Println("APPLY", tx, ty)
+			w.applyPattern(rng, rule, ox, oy, localSymbols, s)
+		case 7:
+			w.log(w.script, rng, w.grid, step, atom, "update", int(rule.Id), s, rx, ry)
+		case 1, 2, 3, 6:
+			// targets relative to the origin can be outside of the rule, and of the grid
+			tx, ty := rx+int(step.Operand[0])*(1-(s&symX)*2), ry+int(step.Operand[1])*(1-((s&symY)>>1)*2)
+			if tx < 0 || ty < 0 || tx >= w.width || ty >= w.height {
+				continue
+			}
+			res, ok := w.evaluateMath(rng, w.grid, step.Eval, step.Vars, step.RandVars, s, rx, ry).(float64)
+			if !ok {
+				continue
+			}
+			name := compile.PropName(step.Name[0])
+			val := float32(res)
+			// fmt.Println(val)
+			switch step.Opcode {
+			case 1:
+				w.grid[ty][tx].prop[name] = val
+			case 2:
+				w.grid[ty][tx].prop[name] += val
+			case 3:
+				if w.grid[ty][tx].prop[name] < val {
+					w.grid[ty][tx].prop[name] = val
+				}
+			case 6:
+				if w.grid[ty][tx].prop[name] > val {
+					w.grid[ty][tx].prop[name] = val
+				}
+			}
+		}
+	}
+}
+
+func randFromRange(rng *rand.Rand, l [3]float64) float64 {
+	amount := int(math.Ceil((l[1] - l[0]) / l[2]))
+	return l[0] + l[2]*float64(rng.Intn(amount))
+}
+
+// evaluateMath evaluates a maths statement for the cell at rx, ry, reading properties from g
+func (sc *script) evaluateMath(rng *rand.Rand, g [][]cell, expr *govaluate.EvaluableExpression, vars map[string][][2]int, randVars map[string][3]float64, s int, rx, ry int) interface{} {
+	// ox, oy absolute position of symbol x
+	param := make(map[string]interface{})
+	inc := make(map[string]int)
+	// fmt.Println("vars", vars)
+	for n, l := range vars {
+		tx, ty := rx, ry
+		// if !(l[inc[n]][0] == -1 && l[inc[n]][1] == -1) {
+		tx += l[inc[n]][0] * -((s&symX)*2 - 1)
+		ty += l[inc[n]][1] * -(((s&symY)>>1)*2 - 1)
+		// }
+		if tx < 0 || ty < 0 || ty >= len(g) || tx >= len(g[ty]) {
+			return false
+		}
+		name := compile.PropName(n)
+		// fmt.Println("name", name, "txy", tx, ty, "rxy", rx, ry, n, g[ty][tx], "expr", expr)
+		// fmt.Println("l", l)
+		target := g[ty][tx]
+
+		if v, ok := target.prop[name]; ok {
+			param[n] = float64(v)
+		} else if v, ok := sc.atoms[sc.idMap[target.t]].ConstProp[name]; ok {
+			param[n] = float64(v)
+		} else if v, ok := sc.prog.Defaults[name]; ok {
+			param[n] = float64(v)
+		}
+	}
+
+	// in a fixed order so that a seed always gives each variable the same value
+	for _, n := range slices.Sorted(maps.Keys(randVars)) {
+		param[n[1:len(n)-1]] = randFromRange(rng, randVars[n])
+	}
+	// fmt.Println("param", param)
+	res, err := expr.Evaluate(param)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (w *World) transfer(from cell, tx, ty int) {
+	w.grid[ty][tx].t = from.t
+	w.grid[ty][tx].prop = make(map[string]float32)
+	for n, v := range from.prop {
+		w.grid[ty][tx].prop[n] = v
+	}
+}
+
+func (w *World) applyPattern(rng *rand.Rand, rule compile.Rule, ox, oy int, symbols map[string]cell, s int) {
+	// fmt.Println("s", s)
+	var cenX, cenY int
+	if s&symX == 0 {
+		cenX = int(rule.Ox)
+	} else {
+		cenX = (int(rule.W) - int(rule.Ox) - 1)
+	}
+
+	if s&symY == 0 {
+		cenY = int(rule.Oy)
+	} else {
+		cenY = (int(rule.H) - int(rule.Oy) - 1)
+	}
+	// (int(rule.H)-int(rule.Oy)-1)
+	tempCentre := w.grid[oy+cenY][ox+cenX]
+	// fmt.Println(tempCentre)
+	// ox, oy := tarX-int(rule.Ox), tarY-int(rule.Oy)
+	// w.transfer(tarX, tarY, int(rule.Ox), int(rule.Oy))
+	for dy := 0; dy < int(rule.H); dy++ {
+		var ruleY int
+		// fmt.Println(s & symY)
+		if s&symY == symY {
+			// fmt.Println("Marker")
+			ruleY = int(rule.H) - dy - 1
+		} else {
+			ruleY = dy
+		}
+
+		for dx := 0; dx < int(rule.W); dx++ {
+			tx, ty := ox+dx, oy+dy
+			var ruleX int
+			if s&symX == 1 {
+				ruleX = int(rule.W) - dx - 1
+			} else {
+				ruleX = dx
+			}
+
+			// fmt.Println("rulePos", ruleX, ruleY)
+			// fmt.Printf("tempCentre %+v\n", tempCentre)
+
+			cellRule := rule.Pat[ruleY*int(rule.W)+ruleX]
+
+			// fmt.Println(cellRule, tx, ty)
+
+			switch cellRule {
+			case "/":
+				continue
+			case "x":
+				// w.grid[ty][tx] = tempCentre
+				w.transfer(tempCentre, tx, ty)
+			case "_":
+				// w.grid[ty][tx].t = w.revIdMap["Empty"]
+				w.changeType(rng, tx, ty, w.revIdMap["Empty"])
+			default:
+				if v, ok := symbols[cellRule]; ok {
+					w.transfer(v, tx, ty)
+				} else if a, ok := w.aliasMap[cellRule]; ok {
+					w.changeType(rng, tx, ty, w.revIdMap[a])
+				}
+			}
+		}
+	}
+	// fmt.Println("END OF APPLY PATTERN")
+	// fmt.Printf("FF: %+v\n", w.grid[4][4])
+}
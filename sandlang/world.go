@@ -0,0 +1,363 @@
+// Package sandlang runs compiled sandlang scripts
+// A World is a grid of cells updated by the rules of a compiled program, several of them can run in one process
+package sandlang
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/compile"
+)
+
+const (
+	symX     = 1 << 0
+	symY     = 1 << 1
+	zoneSize = 10
+	// DefaultWorkers is the number of update threads of a World
+	DefaultWorkers = 7
+)
+
+type cell struct {
+	t    uint16
+	prop map[string]float32
+}
+
+// Cell is the atom and properties of one cell
+type Cell struct {
+	Atom  string
+	Props map[string]float32
+}
+
+// script is a compiled program and the maps made from it, it is replaced as a whole by Reload
+type script struct {
+	prog     *compile.Program
+	atoms    map[string]*compile.AtomRef
+	idMap    map[uint16]string
+	revIdMap map[string]uint16
+	aliasMap map[string]string
+}
+
+func newScript(prog *compile.Program) *script {
+	sc := &script{
+		prog:     prog,
+		atoms:    prog.Atoms,
+		idMap:    make(map[uint16]string),
+		revIdMap: make(map[string]uint16),
+		aliasMap: make(map[string]string),
+	}
+	for name, v := range prog.Atoms {
+		sc.idMap[v.Id] = name
+		sc.revIdMap[name] = v.Id
+		if v.Alias != "" {
+			sc.aliasMap[v.Alias] = name
+		}
+	}
+	return sc
+}
+
+// World is a grid of cells running a compiled program
+type World struct {
+	// only changed while mu is held
+	*script
+
+	width  int
+	height int
+	grid   [][]cell
+	// id of the last rule that fired at each cell, -1 if none did
+	lastRule [][]int32
+	// read locked by an update thread while it updates a cell, the zones keep the threads apart
+	// write locked by everything reading or writing the grid from outside, to keep the threads out
+	mu sync.RWMutex
+
+	// locked around the cell an update thread updates, so threads far enough apart run at the same time
+	zones [][]sync.Mutex
+	// zones locked around the target in each direction, enough to cover the reach of every rule
+	zoneRadius atomic.Int64
+
+	// cells updated since the start
+	updates atomic.Uint64
+	// rules applied since the start
+	applied atomic.Uint64
+	workers int
+	ctl     *controller
+	logf    func(LogEntry)
+
+	seed    int64
+	hasSeed bool
+	// random numbers of each update thread, and of changes made from outside
+	rngs []*rand.Rand
+	rng  *rand.Rand
+	// cell each update thread updates next
+	targets [][2]int
+
+	// closed to stop the threads started by Start
+	quit    chan struct{}
+	running *sync.WaitGroup
+}
+
+// Option changes how a World runs
+type Option func(*World)
+
+// WithWorkers sets the number of update threads
+func WithWorkers(n int) Option {
+	return func(w *World) {
+		w.workers = n
+	}
+}
+
+// WithRate sets the cell updates a second the update threads started by Start aim for, as SetRate does
+func WithRate(updatesPerSecond float64) Option {
+	return func(w *World) {
+		w.ctl.rate = max(0, updatesPerSecond)
+	}
+}
+
+// WithSeed seeds the random numbers of the world. Runs with the same seed and one worker give the same world
+// Without it the seed is random, and can be read with Seed
+func WithSeed(seed int64) Option {
+	return func(w *World) {
+		w.seed = seed
+		w.hasSeed = true
+	}
+}
+
+// WithLog sends the output of log steps to f. f is called from the update threads at the same time, and from Snapshot.Color
+func WithLog(f func(LogEntry)) Option {
+	return func(w *World) {
+		w.logf = f
+	}
+}
+
+// NewWorld makes a w by h world running prog, which must have compiled without errors
+// The world is Empty, apart from the cells set by the world sections of the script. Cells they set outside of the world are left out
+func NewWorld(prog *compile.Program, w, h int, opts ...Option) (*World, error) {
+	world, err := newWorld(prog, w, h, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prog.World {
+		t := world.revIdMap[p.Atom]
+		for y := max(0, p.Y); y < min(h, p.Y+p.H); y++ {
+			for x := max(0, p.X); x < min(w, p.X+p.W); x++ {
+				world.changeType(world.rng, x, y, t)
+			}
+		}
+	}
+	return world, nil
+}
+
+// newWorld makes a world of Empty
+func newWorld(prog *compile.Program, w, h int, opts []Option) (*World, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("world size %vx%v is not positive", w, h)
+	}
+	if _, ok := prog.Atoms["Empty"]; !ok {
+		return nil, fmt.Errorf("program has no Empty atom")
+	}
+
+	world := &World{
+		script: newScript(prog),
+		width:  w,
+		height: h,
+		// zones at the right and bottom edges are smaller if the size is not a multiple of zoneSize
+		zones:   make([][]sync.Mutex, (h+zoneSize-1)/zoneSize),
+		workers: DefaultWorkers,
+		ctl:     newController(),
+	}
+	world.zoneRadius.Store(zoneRadius(prog))
+	for _, o := range opts {
+		o(world)
+	}
+	if world.workers < 1 {
+		return nil, fmt.Errorf("need at least 1 worker, got %v", world.workers)
+	}
+	if !world.hasSeed {
+		world.seed = time.Now().UnixNano()
+	}
+	// each update thread has its own stream, so a thread does not depend on how the others were scheduled
+	world.rng = rand.New(rand.NewSource(world.seed))
+	for i := range world.workers {
+		rng := rand.New(rand.NewSource(world.seed + int64(i) + 1))
+		world.rngs = append(world.rngs, rng)
+		world.targets = append(world.targets, [2]int{rng.Intn(w), rng.Intn(h)})
+	}
+
+	for zy := range world.zones {
+		world.zones[zy] = make([]sync.Mutex, (w+zoneSize-1)/zoneSize)
+	}
+
+	empty := world.revIdMap["Empty"]
+	world.grid = make([][]cell, h)
+	for yi := range world.grid {
+		world.grid[yi] = make([]cell, w)
+		for xi := range world.grid[yi] {
+			world.grid[yi][xi] = cell{t: empty, prop: make(map[string]float32)}
+		}
+	}
+	world.clearLastRules()
+	return world, nil
+}
+
+// zoneRadius gives the zones to lock around a cell for the reach of the rules of prog
+func zoneRadius(prog *compile.Program) int64 {
+	return int64(max(1, (prog.Reach+zoneSize-1)/zoneSize))
+}
+
+// Program gives the program the world runs
+func (w *World) Program() *compile.Program {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.prog
+}
+
+// Seed gives the seed of the world's random numbers
+func (w *World) Seed() int64 {
+	return w.seed
+}
+
+// Size gives the width and height of the world
+func (w *World) Size() (int, int) {
+	return w.width, w.height
+}
+
+// Updates gives the number of cells updated since the start
+func (w *World) Updates() uint64 {
+	return w.updates.Load()
+}
+
+// RulesApplied gives the number of rules applied since the start
+func (w *World) RulesApplied() uint64 {
+	return w.applied.Load()
+}
+
+// Tick gives the number of ticks run, a tick updates as many cells as there are in the world
+func (w *World) Tick() uint64 {
+	return w.updates.Load() / uint64(w.width*w.height)
+}
+
+// startWorkers starts the update threads, see work
+func (w *World) startWorkers(quit chan struct{}, limit uint64, gated bool) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := range w.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(i, quit, limit, gated)
+		}()
+	}
+	return &wg
+}
+
+// Step runs n ticks as fast as possible and returns once they are done
+// It must not be called while the world was started with Start
+func (w *World) Step(n int) {
+	if n <= 0 {
+		return
+	}
+	limit := w.updates.Load() + uint64(n)*uint64(w.width*w.height)
+	w.startWorkers(nil, limit, false).Wait()
+}
+
+// Start runs the update threads in the background until Stop is called
+func (w *World) Start() {
+	if w.running != nil {
+		return
+	}
+	w.quit = make(chan struct{})
+	w.running = w.startWorkers(w.quit, 0, true)
+}
+
+// Stop stops the update threads started by Start and waits for them to finish
+func (w *World) Stop() {
+	if w.running == nil {
+		return
+	}
+	close(w.quit)
+	// wake the threads waiting while paused
+	w.ctl.mu.Lock()
+	w.ctl.cond.Broadcast()
+	w.ctl.mu.Unlock()
+	w.running.Wait()
+	w.running = nil
+}
+
+func (w *World) inBounds(x, y int) error {
+	if !w.inGrid(x, y) {
+		return fmt.Errorf("cell %v, %v is outside of the %vx%v world", x, y, w.width, w.height)
+	}
+	return nil
+}
+
+// Cell gives the cell at x, y. ok is false if it is outside of the world
+func (w *World) Cell(x, y int) (c Cell, ok bool) {
+	if !w.inGrid(x, y) {
+		return Cell{}, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.grid[y][x].export(w.idMap), true
+}
+
+// SetCell changes the cell at x, y to atom with its default properties, and runs its init section
+func (w *World) SetCell(x, y int, atom string) error {
+	if err := w.inBounds(x, y); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.revIdMap[atom]
+	if !ok {
+		return fmt.Errorf("no atom %v", atom)
+	}
+	w.changeType(w.rng, x, y, t)
+	return nil
+}
+
+// SetProp sets the property name of the cell at x, y
+func (w *World) SetProp(x, y int, name string, v float32) error {
+	if err := w.inBounds(x, y); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.grid[y][x].prop[name] = v
+	return nil
+}
+
+// Fill changes every cell to atom, like SetCell
+func (w *World) Fill(atom string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.revIdMap[atom]
+	if !ok {
+		return fmt.Errorf("no atom %v", atom)
+	}
+	for yi := range w.grid {
+		for xi := range w.grid[yi] {
+			w.changeType(w.rng, xi, yi, t)
+		}
+	}
+	return nil
+}
+
+// clearLastRules forgets the rules that fired, for when the grid or the rules were replaced
+func (w *World) clearLastRules() {
+	w.lastRule = make([][]int32, w.height)
+	for yi := range w.lastRule {
+		w.lastRule[yi] = make([]int32, w.width)
+		for xi := range w.lastRule[yi] {
+			w.lastRule[yi][xi] = -1
+		}
+	}
+}
+
+func (c cell) export(idMap map[uint16]string) Cell {
+	props := make(map[string]float32, len(c.prop))
+	for k, v := range c.prop {
+		props[k] = v
+	}
+	return Cell{Atom: idMap[c.t], Props: props}
+}